@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/threatspec/threatspec-go/threatspec"
+	"io/ioutil"
+	"os"
+)
+
+func main() {
+	outFile := flag.String("out", "threatspec-baseline.json", "baseline file; suppressions already in it are kept")
+	flag.Parse()
+
+	ts, err := threatspec.LoadFiles(flag.Args())
+	if err != nil {
+		fmt.Println(err)
+		if diagnostics, ok := err.(threatspec.Diagnostics); !ok || diagnostics.HasErrors() {
+			os.Exit(2)
+		}
+	}
+
+	baseline := threatspec.NewBaseline(ts)
+	if _, err := os.Stat(*outFile); err == nil {
+		previous, err := threatspec.LoadBaseline(*outFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		baseline.Suppressions = previous.Suppressions
+	}
+
+	if err := ioutil.WriteFile(*outFile, []byte(baseline.ToJson()), 0644); err != nil {
+		fmt.Println("Error writing file")
+		fmt.Println(err)
+		os.Exit(3)
+	}
+
+	fmt.Printf("Baseline of %d findings written to %s\n", len(baseline.Findings), *outFile)
+	os.Exit(0)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/threatspec/threatspec-go/threatspec"
+	"os"
+	"strings"
+)
+
+func main() {
+	search := flag.String("search", "", "only list entries whose id, name or description contain all of these words")
+	flag.Parse()
+
+	// Entries named on the command line are shown in full
+	if flag.NArg() > 0 {
+		status := 0
+		for _, reference := range flag.Args() {
+			entry := threatspec.LookupCatalog(reference)
+			if entry == nil {
+				fmt.Printf("%s not found in catalog\n", reference)
+				status = 1
+				continue
+			}
+			fmt.Printf("%s %s\n  id: %s\n  %s\n  %s\n", entry.Id, entry.Name, entry.ThreatId(), entry.Description, entry.Url)
+		}
+		os.Exit(status)
+	}
+
+	entries := threatspec.Catalog()
+	if strings.TrimSpace(*search) != "" {
+		entries = threatspec.SearchCatalog(*search)
+	}
+	for _, entry := range entries {
+		fmt.Printf("%-10s %s\n", entry.Id, entry.Name)
+	}
+
+	if len(entries) == 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
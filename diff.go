@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/threatspec/threatspec-go/threatspec"
+	"os"
+)
+
+func main() {
+	format := flag.String("format", "text", "output format: text, json or markdown")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		fmt.Println("usage: diff [-format text|json|markdown] old.json new.json")
+		os.Exit(2)
+	}
+
+	a, err := threatspec.Load(flag.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	b, err := threatspec.Load(flag.Arg(1))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	d := threatspec.Diff(a, b)
+	switch *format {
+	case "text":
+		if !d.Empty() {
+			fmt.Println(d.Text())
+		}
+	case "json":
+		fmt.Println(d.ToJson())
+	case "markdown":
+		fmt.Print(d.Markdown())
+	default:
+		fmt.Printf("unknown format %q, expected text, json or markdown\n", *format)
+		os.Exit(2)
+	}
+	os.Exit(0)
+}
@@ -6,17 +6,68 @@ import (
 	"github.com/threatspec/threatspec-go/threatspec"
 	"io/ioutil"
 	"os"
+	"strings"
 )
 
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
 func main() {
 	project := flag.String("project", "default", "project name")
 	outFile := flag.String("out", "threatspec.json", "output file")
+	include := flag.String("include", "", "comma separated globs of files to include when scanning directories")
+	exclude := flag.String("exclude", "", "comma separated globs of files and directories to exclude when scanning directories")
+	tags := flag.String("tags", "", "comma separated build tags used when loading Go packages")
+	goos := flag.String("goos", "", "GOOS used when loading Go packages")
+	goarch := flag.String("goarch", "", "GOARCH used when loading Go packages")
+	callFlow := flag.Bool("callflow", true, "build the call flow between annotated functions")
+	mergePolicy := flag.String("merge", "prefer-left", "how conflicts with JSON specs are resolved: prefer-left, prefer-right or fail")
+	scoring := flag.String("scoring", "likelihood-impact", "risk scoring model: likelihood-impact or dread")
+	formula := flag.String("formula", "", "custom risk scoring formula, such as severity*likelihood/(1+mitigations), used instead of --scoring")
+	projects := flag.String("projects", "", "comma separated pattern=project rules assigning files to projects, such as services/billing/...=billing")
 	flag.Parse()
 
 	ts := threatspec.New(*project)
+	ts.Options.Include = splitList(*include)
+	ts.Options.Exclude = splitList(*exclude)
+	ts.Options.Tags = splitList(*tags)
+	ts.Options.GOOS = *goos
+	ts.Options.GOARCH = *goarch
+	ts.Options.CallFlow = *callFlow
+	policy, err := threatspec.ParseMergePolicy(*mergePolicy)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	ts.Options.MergePolicy = policy
+	scorer, err := threatspec.ParseScorer(*scoring, *formula)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	for _, rule := range splitList(*projects) {
+		projectRule, err := threatspec.ParseProjectRule(rule)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		ts.Options.Projects = append(ts.Options.Projects, projectRule)
+	}
 	if err := ts.Parse(flag.Args()); err != nil {
+		// Diagnostics are printed one per line as file:line:col: message
+		fmt.Println(err)
+		if diagnostics, ok := err.(threatspec.Diagnostics); !ok || diagnostics.HasErrors() {
+			os.Exit(1)
+		}
+	}
+
+	if err := ts.Score(scorer); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(2)
 	}
 
 	if err := ts.Validate(); err != nil {
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/threatspec/threatspec-go/threatspec"
+	"io/ioutil"
+	"os"
+)
+
+func main() {
+	outFile := flag.String("out", "threatspec.json", "output file")
+	mergePolicy := flag.String("merge", "prefer-left", "how conflicting definitions are resolved: prefer-left, prefer-right or fail")
+	scoring := flag.String("scoring", "likelihood-impact", "risk scoring model: likelihood-impact or dread")
+	formula := flag.String("formula", "", "custom risk scoring formula, used instead of --scoring")
+	flag.Parse()
+
+	policy, err := threatspec.ParseMergePolicy(*mergePolicy)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	scorer, err := threatspec.ParseScorer(*scoring, *formula)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	ts := new(threatspec.ThreatSpec)
+	ts.Options.MergePolicy = policy
+	failed := false
+	for _, filename := range flag.Args() {
+		if err := ts.LoadFile(filename); err != nil {
+			fmt.Println(err)
+			if diagnostics, ok := err.(threatspec.Diagnostics); !ok || diagnostics.HasErrors() {
+				failed = true
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+
+	// Merging drops the scores of the inputs
+	if err := ts.Score(scorer); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	// Libraries only have to be complete once merged into a spec
+	if err := ts.Validate(); err != nil {
+		fmt.Println("WARNING: JSON validation failed")
+		fmt.Println(err)
+	}
+
+	if err := ioutil.WriteFile(*outFile, []byte(ts.ToJson()), 0644); err != nil {
+		fmt.Println("Error writing file")
+		fmt.Println(err)
+		os.Exit(3)
+	}
+
+	fmt.Printf("ThreatSpec written to %s\n", *outFile)
+	os.Exit(0)
+}
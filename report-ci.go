@@ -1,40 +1,327 @@
 package main
 
 import (
+	"encoding/xml"
 	"flag"
 	"fmt"
 	"github.com/threatspec/threatspec-go/threatspec"
+	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
+	"time"
 )
 
+type warning struct {
+	score   float64
+	message string
+}
+
+// describeSource says where an annotation was made, which for annotations in
+// spec files is only a file and line.
+func describeSource(source *threatspec.Source) string {
+	if source == nil {
+		return ""
+	}
+	if source.Function == "" {
+		return fmt.Sprintf(" (%s:%d)", source.File, source.Line)
+	}
+	return fmt.Sprintf(" in %s (%s:%d)", source.Function, source.File, source.Line)
+}
+
+func describeExposure(ts *threatspec.ThreatSpec, projectName string, exposure *threatspec.Exposure) (string, float64) {
+	description := fmt.Sprintf("%s:%s exposed to %s by %s",
+		ts.BoundaryName(exposure.Boundary),
+		ts.ComponentName(exposure.Component),
+		ts.ThreatName(exposure.Threat),
+		exposure.Exposure) + describeSource(exposure.Source)
+
+	score := 0.0
+	if risk := ts.Risk(projectName, exposure.Boundary, exposure.Component, exposure.Threat); risk != nil {
+		description = fmt.Sprintf("[%s %.1f] %s", risk.Level, risk.Score, description)
+		score = risk.Score
+	}
+	return description, score
+}
+
+func describeAnnotation(ts *threatspec.ThreatSpec, annotation *threatspec.Annotation) string {
+	if exposure, ok := annotation.Value.(*threatspec.Exposure); ok {
+		description, _ := describeExposure(ts, annotation.Project, exposure)
+		return description
+	}
+
+	return fmt.Sprintf("%s %s:%s against %s with %s",
+		annotation.Kind,
+		ts.BoundaryName(annotation.Boundary),
+		ts.ComponentName(annotation.Component),
+		ts.ThreatName(annotation.Threat),
+		annotation.Text) + describeSource(annotation.Source)
+}
+
+// describeFinding describes a baseline finding, which may refer to
+// boundaries, components and threats that are no longer in ts.
+func describeFinding(ts *threatspec.ThreatSpec, finding *threatspec.Finding) string {
+	description := fmt.Sprintf("%s:%s exposed to %s by %s",
+		ts.BoundaryName(finding.Boundary),
+		ts.ComponentName(finding.Component),
+		ts.ThreatName(finding.Threat),
+		finding.Exposure)
+	if finding.Function != "" {
+		description += " in " + finding.Function
+	}
+	return description
+}
+
+// reportBaseline lists the baseline findings that have been fixed and the
+// suppressions that have expired.
+func reportBaseline(ts *threatspec.ThreatSpec, baseline *threatspec.Baseline, now time.Time) {
+	for _, finding := range baseline.Removed(ts) {
+		fmt.Printf("INFO removed since baseline: %s (fingerprint %s)\n", describeFinding(ts, finding), finding.Fingerprint)
+	}
+	for _, suppression := range baseline.Suppressions {
+		if suppression.Expired(now) {
+			fmt.Printf("WARNING suppression of %s expired on %s: %s\n", suppression.Fingerprint, suppression.Expires, suppression.Justification)
+		}
+	}
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Name     string            `xml:"name,attr"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Skipped  int               `xml:"skipped,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Cases    []*junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// writeJUnit records every boundary, component and threat of each project as
+// a testcase, in a testsuite per project. It passes when mitigated,
+// transferred or accepted, and fails when exposed, unless each exposure is
+// cleared by the reachability analysis or, when skipped, known to the
+// baseline.
+func writeJUnit(ts *threatspec.ThreatSpec, filename string, known func(string, *threatspec.Exposure) bool, cleared map[*threatspec.Exposure]bool) error {
+	suites := &junitTestSuites{Name: "threatspec"}
+
+	for _, projectName := range ts.ProjectNames() {
+		project := ts.Projects[projectName]
+		suite := &junitTestSuite{Name: projectName}
+
+		for _, risk := range project.Risks {
+			testcase := &junitTestCase{
+				Name:      ts.ThreatName(risk.Threat),
+				Classname: ts.BoundaryName(risk.Boundary) + ":" + ts.ComponentName(risk.Component),
+			}
+			suite.Cases = append(suite.Cases, testcase)
+			if risk.Mitigations > 0 || risk.Transferred || risk.Accepted {
+				continue
+			}
+
+			failures := make([]string, 0)
+			skipped := false
+			for _, exposures := range project.Exposures {
+				for _, exposure := range exposures {
+					if exposure.Boundary != risk.Boundary || exposure.Component != risk.Component || exposure.Threat != risk.Threat || cleared[exposure] {
+						continue
+					}
+					if known(projectName, exposure) {
+						skipped = true
+						continue
+					}
+					description, _ := describeExposure(ts, projectName, exposure)
+					failures = append(failures, description)
+				}
+			}
+			sort.Strings(failures)
+
+			if len(failures) > 0 {
+				testcase.Failure = &junitFailure{Message: failures[0], Type: "exposure", Text: strings.Join(failures, "\n")}
+				suite.Failures++
+			} else if skipped {
+				testcase.Skipped = &junitSkipped{Message: "exposure is in the baseline or suppressed"}
+				suite.Skipped++
+			}
+		}
+
+		suite.Tests = len(suite.Cases)
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	dump, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append([]byte(xml.Header), append(dump, '\n')...), 0644)
+}
+
+// checkPolicies evaluates the policy files instead of failing on every
+// exposure. Exposures for which skip returns true are not checked.
+func checkPolicies(ts *threatspec.ThreatSpec, filenames []string, skip func(string, *threatspec.Exposure) bool) {
+	failed := false
+	for _, filename := range filenames {
+		policy, err := threatspec.LoadPolicy(filename)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+
+		for _, violation := range policy.Evaluate(ts, time.Now()) {
+			if exposure, ok := violation.Annotation.Value.(*threatspec.Exposure); ok && skip(violation.Annotation.Project, exposure) {
+				continue
+			}
+			level := "WARNING"
+			if violation.Rule.Level == threatspec.SeverityError {
+				level = "ERROR"
+				failed = true
+			}
+			fmt.Printf("%s [%s] %s: %s\n", level, violation.Rule.Name, violation.Message, describeAnnotation(ts, violation.Annotation))
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+	fmt.Println("OK")
+	os.Exit(0)
+}
+
 func main() {
+	reachability := flag.Bool("reachability", false, "only fail on exposures reachable from an entry point without passing a matching mitigation")
+	policies := flag.String("policy", "", "comma separated policy files; fail only on rule violations rather than on every exposure")
+	baselineFile := flag.String("baseline", "", "baseline file; only fail on exposures that are neither in it nor suppressed")
+	junitFile := flag.String("junit", "", "also write each boundary, component and threat as a testcase to this JUnit XML file")
 	flag.Parse()
 	ts, err := threatspec.LoadFiles(flag.Args())
 	if err != nil {
 		fmt.Println(err)
-		os.Exit(2)
+		if diagnostics, ok := err.(threatspec.Diagnostics); !ok || diagnostics.HasErrors() {
+			os.Exit(2)
+		}
 	}
-	exposuresFound := false
+	ts.EnsureScores()
+	warnings := make([]warning, 0)
+	// Exposures the reachability analysis shows cannot be reached unmitigated
+	cleared := make(map[*threatspec.Exposure]bool)
+	now := time.Now()
 
-	for projectName, _ := range ts.Projects {
-		if len(ts.Projects[projectName].Exposures) > 0 {
-			exposuresFound = true
-			for _, exposures := range ts.Projects[projectName].Exposures {
-				for _, exposure := range exposures {
-					fmt.Printf("WARNING %s:%s exposed to %s by %s in %s (%s:%d)\n",
-						ts.Boundaries[exposure.Boundary].Name,
-						ts.Components[exposure.Component].Name,
-						ts.Threats[exposure.Threat].Name,
-						exposure.Exposure,
-						exposure.Source.Function,
-						exposure.Source.File,
-						exposure.Source.Line)
+	var baseline *threatspec.Baseline
+	if *baselineFile != "" {
+		if baseline, err = threatspec.LoadBaseline(*baselineFile); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		reportBaseline(ts, baseline, now)
+	}
+
+	// Known exposures are in the baseline or suppressed, and never fail
+	fingerprint := func(project string, exposure *threatspec.Exposure) string {
+		return threatspec.Fingerprint(project, "exposure", exposure.Boundary, exposure.Component, exposure.Threat, exposure.Source)
+	}
+	known := func(project string, exposure *threatspec.Exposure) bool {
+		return baseline != nil && baseline.Covers(fingerprint(project, exposure), now)
+	}
+	newWarning := func(score float64, message string, project string, exposure *threatspec.Exposure) warning {
+		if baseline != nil {
+			message += fmt.Sprintf(" (fingerprint %s)", fingerprint(project, exposure))
+		}
+		return warning{score, message}
+	}
+
+	if *reachability {
+		results, err := ts.AnalyseReachability(nil)
+		if err != nil {
+			// Without a call flow every exposure is reported as it would be
+			// without --reachability
+			fmt.Printf("WARNING %s, reporting every exposure\n", err)
+			*reachability = false
+		}
+		for _, result := range results {
+			description, score := describeExposure(ts, result.Project, result.Exposure)
+			if !result.Reachable {
+				cleared[result.Exposure] = true
+				fmt.Printf("INFO %s is not reachable from any entry point\n", description)
+			} else if result.Mitigated {
+				cleared[result.Exposure] = true
+				fmt.Printf("INFO %s is mitigated upstream by %s\n",
+					description,
+					strings.Join(result.Mitigations, ", "))
+			} else if !known(result.Project, result.Exposure) {
+				warnings = append(warnings, newWarning(score, fmt.Sprintf("%s is reachable unmitigated via %s",
+					description,
+					strings.Join(result.Path, " -> ")), result.Project, result.Exposure))
+			}
+		}
+	}
+
+	if *junitFile != "" {
+		if err := writeJUnit(ts, *junitFile, known, cleared); err != nil {
+			fmt.Println("Error writing file")
+			fmt.Println(err)
+			os.Exit(3)
+		}
+	}
+
+	if *policies != "" {
+		checkPolicies(ts, strings.Split(*policies, ","), func(project string, exposure *threatspec.Exposure) bool {
+			return cleared[exposure] || known(project, exposure)
+		})
+	}
+
+	for projectName := range ts.Projects {
+		for _, exposures := range ts.Projects[projectName].Exposures {
+			for _, exposure := range exposures {
+				// Exposures with a source function were analysed above
+				if *reachability && exposure.Source != nil && exposure.Source.Function != "" {
+					continue
+				}
+				if known(projectName, exposure) {
+					continue
 				}
+				description, score := describeExposure(ts, projectName, exposure)
+				warnings = append(warnings, newWarning(score, description, projectName, exposure))
 			}
 		}
 	}
 
-	if exposuresFound {
+	// Highest risks first
+	sort.SliceStable(warnings, func(i, j int) bool {
+		if warnings[i].score != warnings[j].score {
+			return warnings[i].score > warnings[j].score
+		}
+		return warnings[i].message < warnings[j].message
+	})
+	for _, w := range warnings {
+		fmt.Printf("WARNING %s\n", w.message)
+	}
+
+	if len(warnings) > 0 {
 		os.Exit(1)
 	} else {
 		fmt.Println("OK")
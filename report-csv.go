@@ -16,11 +16,19 @@ func fatalIfError(err error) {
 	}
 }
 
-func getBoundaryName(boundary *threatspec.Boundary) string {
-	if boundary == nil {
+func getRiskScore(risk *threatspec.Risk) string {
+	if risk == nil {
 		return ""
 	} else {
-		return boundary.Name
+		return strconv.FormatFloat(risk.Score, 'f', 1, 64)
+	}
+}
+
+func getRiskLevel(risk *threatspec.Risk) string {
+	if risk == nil {
+		return ""
+	} else {
+		return risk.Level
 	}
 }
 
@@ -32,7 +40,12 @@ func main() {
 	var err error
 
 	ts, err := threatspec.LoadFiles(flag.Args())
+	if diagnostics, ok := err.(threatspec.Diagnostics); ok && !diagnostics.HasErrors() {
+		fmt.Println(err)
+		err = nil
+	}
 	fatalIfError(err)
+	ts.EnsureScores()
 
 	csvFile, err := os.Create(*outFile)
 	fatalIfError(err)
@@ -50,21 +63,25 @@ func main() {
 		"function",
 		"file",
 		"line",
+		"score",
+		"level",
 	})
 
-	for projectName, _ := range ts.Projects {
+	for projectName := range ts.Projects {
 
 		for _, ms := range ts.Projects[projectName].Mitigations {
 			for _, m := range ms {
 				err = writer.Write([]string{
-					getBoundaryName(ts.Boundaries[m.Boundary]),
-					ts.Components[m.Component].Name,
-					ts.Threats[m.Threat].Name,
+					ts.BoundaryName(m.Boundary),
+					ts.ComponentName(m.Component),
+					ts.ThreatName(m.Threat),
 					"mitigation",
 					m.Mitigation,
 					m.Source.Function,
 					m.Source.File,
 					strconv.Itoa(m.Source.Line),
+					getRiskScore(ts.Risk(projectName, m.Boundary, m.Component, m.Threat)),
+					getRiskLevel(ts.Risk(projectName, m.Boundary, m.Component, m.Threat)),
 				})
 				fatalIfError(err)
 			}
@@ -73,14 +90,16 @@ func main() {
 		for _, es := range ts.Projects[projectName].Exposures {
 			for _, e := range es {
 				err = writer.Write([]string{
-					getBoundaryName(ts.Boundaries[e.Boundary]),
-					ts.Components[e.Component].Name,
-					ts.Threats[e.Threat].Name,
+					ts.BoundaryName(e.Boundary),
+					ts.ComponentName(e.Component),
+					ts.ThreatName(e.Threat),
 					"exposure",
 					e.Exposure,
 					e.Source.Function,
 					e.Source.File,
 					strconv.Itoa(e.Source.Line),
+					getRiskScore(ts.Risk(projectName, e.Boundary, e.Component, e.Threat)),
+					getRiskLevel(ts.Risk(projectName, e.Boundary, e.Component, e.Threat)),
 				})
 				fatalIfError(err)
 			}
@@ -89,14 +108,16 @@ func main() {
 		for _, trs := range ts.Projects[projectName].Transfers {
 			for _, t := range trs {
 				err = writer.Write([]string{
-					getBoundaryName(ts.Boundaries[t.Boundary]),
-					ts.Components[t.Component].Name,
-					ts.Threats[t.Threat].Name,
+					ts.BoundaryName(t.Boundary),
+					ts.ComponentName(t.Component),
+					ts.ThreatName(t.Threat),
 					"transfer",
 					t.Transfer,
 					t.Source.Function,
 					t.Source.File,
 					strconv.Itoa(t.Source.Line),
+					getRiskScore(ts.Risk(projectName, t.Boundary, t.Component, t.Threat)),
+					getRiskLevel(ts.Risk(projectName, t.Boundary, t.Component, t.Threat)),
 				})
 				fatalIfError(err)
 			}
@@ -105,14 +126,16 @@ func main() {
 		for _, as := range ts.Projects[projectName].Acceptances {
 			for _, a := range as {
 				err = writer.Write([]string{
-					getBoundaryName(ts.Boundaries[a.Boundary]),
-					ts.Components[a.Component].Name,
-					ts.Threats[a.Threat].Name,
+					ts.BoundaryName(a.Boundary),
+					ts.ComponentName(a.Component),
+					ts.ThreatName(a.Threat),
 					"acceptance",
 					a.Acceptance,
 					a.Source.Function,
 					a.Source.File,
 					strconv.Itoa(a.Source.Line),
+					getRiskScore(ts.Risk(projectName, a.Boundary, a.Component, a.Threat)),
+					getRiskLevel(ts.Risk(projectName, a.Boundary, a.Component, a.Threat)),
 				})
 				fatalIfError(err)
 			}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/threatspec/threatspec-go/threatspec"
+	"io/ioutil"
+	"os"
+)
+
+var extensions = map[string]string{
+	"dot":      "dot",
+	"mermaid":  "mmd",
+	"plantuml": "puml",
+}
+
+func main() {
+	format := flag.String("format", "dot", "diagram format: dot, mermaid or plantuml")
+	outFile := flag.String("out", "", "output file, or - for stdout, threatspec.dot, threatspec.mmd or threatspec.puml by default")
+	flag.Parse()
+
+	extension, ok := extensions[*format]
+	if !ok {
+		fmt.Printf("unknown format %q, expected dot, mermaid or plantuml\n", *format)
+		os.Exit(2)
+	}
+	if *outFile == "" {
+		*outFile = "threatspec." + extension
+	}
+
+	ts, err := threatspec.LoadFiles(flag.Args())
+	if err != nil {
+		fmt.Println(err)
+		if diagnostics, ok := err.(threatspec.Diagnostics); !ok || diagnostics.HasErrors() {
+			os.Exit(2)
+		}
+	}
+
+	flow := ts.DataFlow()
+	diagram := flow.Dot()
+	switch *format {
+	case "mermaid":
+		diagram = flow.Mermaid()
+	case "plantuml":
+		diagram = flow.PlantUml()
+	}
+
+	if *outFile == "-" {
+		fmt.Print(diagram)
+		os.Exit(0)
+	}
+
+	if err := ioutil.WriteFile(*outFile, []byte(diagram), 0644); err != nil {
+		fmt.Println("Error writing file")
+		fmt.Println(err)
+		os.Exit(3)
+	}
+
+	fmt.Printf("Data flow diagram written to %s\n", *outFile)
+	os.Exit(0)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/threatspec/threatspec-go/threatspec"
+	"html/template"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type counts struct {
+	Boundaries  int
+	Components  int
+	Threats     int
+	Mitigations int
+	Exposures   int
+	Transfers   int
+	Acceptances int
+}
+
+func (c *counts) add(kind string) {
+	switch kind {
+	case "mitigation":
+		c.Mitigations++
+	case "exposure":
+		c.Exposures++
+	case "transfer":
+		c.Transfers++
+	case "acceptance":
+		c.Acceptances++
+	}
+}
+
+type annotationGroup struct {
+	Kind        string
+	Annotations []*threatspec.Annotation
+}
+
+type threatSection struct {
+	Anchor string
+	Id     threatspec.Id
+	Threat *threatspec.Threat
+	Name   string
+	Risk   *threatspec.Risk
+	Groups []*annotationGroup
+}
+
+type componentSection struct {
+	Anchor  string
+	Name    string
+	Status  string
+	Threats []*threatSection
+}
+
+type boundarySection struct {
+	Anchor     string
+	Name       string
+	Components []*componentSection
+}
+
+type projectSection struct {
+	Anchor     string
+	Name       string
+	Counts     counts
+	Risks      []*threatspec.Risk
+	Boundaries []*boundarySection
+}
+
+type report struct {
+	Generated string
+	Counts    counts
+	Diagram   template.HTML
+	Projects  []*projectSection
+}
+
+var kinds = []string{"mitigation", "exposure", "transfer", "acceptance"}
+
+func anchor(parts ...interface{}) string {
+	names := make([]string, 0, len(parts))
+	for _, part := range parts {
+		names = append(names, strings.TrimPrefix(fmt.Sprint(part), "@"))
+	}
+	return strings.Join(names, "-")
+}
+
+// kindTitles are the headings of the sections for each kind of annotation.
+var kindTitles = map[string]string{
+	"mitigation": "Mitigation",
+	"exposure":   "Exposure",
+	"transfer":   "Transfer",
+	"acceptance": "Acceptance",
+}
+
+// buildReport groups the annotations of each project by boundary, then
+// component, then threat.
+func buildReport(ts *threatspec.ThreatSpec) *report {
+	flow := ts.DataFlow()
+	r := &report{
+		Generated: time.Now().Format("2006-01-02 15:04"),
+		Diagram:   template.HTML(flow.Svg()),
+		Projects:  make([]*projectSection, 0),
+	}
+	r.Counts.Boundaries = len(ts.Boundaries)
+	r.Counts.Components = len(ts.Components)
+	r.Counts.Threats = len(ts.Threats)
+
+	statuses := make(map[string]string)
+	for _, b := range flow.Boundaries {
+		for _, n := range b.Nodes {
+			statuses[anchor(n.Boundary, n.Component)] = n.Status
+		}
+	}
+
+	projects := make(map[string]*projectSection)
+	boundaries := make(map[string]*boundarySection)
+	components := make(map[string]*componentSection)
+	threats := make(map[string]*threatSection)
+	seen := make(map[string]map[threatspec.Id]bool)
+
+	for _, annotation := range ts.Annotations() {
+		p := projects[annotation.Project]
+		if p == nil {
+			p = &projectSection{Anchor: anchor(annotation.Project), Name: annotation.Project, Boundaries: make([]*boundarySection, 0)}
+			for _, risk := range ts.Projects[annotation.Project].Risks {
+				p.Risks = append(p.Risks, risk)
+			}
+			sort.SliceStable(p.Risks, func(i, j int) bool { return p.Risks[i].Score > p.Risks[j].Score })
+			projects[annotation.Project] = p
+			r.Projects = append(r.Projects, p)
+			seen[annotation.Project] = make(map[threatspec.Id]bool)
+		}
+
+		key := anchor(annotation.Project, annotation.Boundary)
+		b := boundaries[key]
+		if b == nil {
+			b = &boundarySection{Anchor: key, Name: ts.BoundaryName(annotation.Boundary)}
+			boundaries[key] = b
+			p.Boundaries = append(p.Boundaries, b)
+			p.Counts.Boundaries++
+		}
+
+		key = anchor(annotation.Project, annotation.Boundary, annotation.Component)
+		c := components[key]
+		if c == nil {
+			c = &componentSection{Anchor: key, Name: ts.ComponentName(annotation.Component), Status: statuses[anchor(annotation.Boundary, annotation.Component)]}
+			components[key] = c
+			b.Components = append(b.Components, c)
+			p.Counts.Components++
+		}
+
+		key = anchor(annotation.Project, annotation.Boundary, annotation.Component, annotation.Threat)
+		t := threats[key]
+		if t == nil {
+			t = &threatSection{
+				Anchor: key,
+				Id:     annotation.Threat,
+				Threat: ts.Threats[annotation.Threat],
+				Name:   ts.ThreatName(annotation.Threat),
+				Risk:   ts.Risk(annotation.Project, annotation.Boundary, annotation.Component, annotation.Threat),
+			}
+			for _, kind := range kinds {
+				t.Groups = append(t.Groups, &annotationGroup{Kind: kind})
+			}
+			threats[key] = t
+			c.Threats = append(c.Threats, t)
+			if !seen[annotation.Project][annotation.Threat] {
+				seen[annotation.Project][annotation.Threat] = true
+				p.Counts.Threats++
+			}
+		}
+
+		for _, group := range t.Groups {
+			if group.Kind == annotation.Kind {
+				group.Annotations = append(group.Annotations, annotation)
+			}
+		}
+		p.Counts.add(annotation.Kind)
+		r.Counts.add(annotation.Kind)
+	}
+
+	sort.Slice(r.Projects, func(i, j int) bool { return r.Projects[i].Name < r.Projects[j].Name })
+	for _, p := range r.Projects {
+		sort.Slice(p.Boundaries, func(i, j int) bool { return p.Boundaries[i].Name < p.Boundaries[j].Name })
+		for _, b := range p.Boundaries {
+			sort.Slice(b.Components, func(i, j int) bool { return b.Components[i].Name < b.Components[j].Name })
+			for _, c := range b.Components {
+				sort.Slice(c.Threats, func(i, j int) bool { return c.Threats[i].Name < c.Threats[j].Name })
+			}
+		}
+	}
+	return r
+}
+
+const reportTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Threat model</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1, h2, h3, h4 { margin-bottom: 0.3em; }
+table { border-collapse: collapse; margin: 0.5em 0 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f3f3f3; }
+.counts td { text-align: right; }
+.diagram { overflow-x: auto; border: 1px solid #ddd; padding: 0.5em; }
+.status { padding: 1px 6px; border-radius: 3px; font-size: 0.85em; }
+.exposed { background: #f8cecc; }
+.accepted { background: #ffe6cc; }
+.mitigated { background: #d5e8d4; }
+.low { color: #2e7d32; } .medium { color: #b26a00; } .high { color: #c62828; } .critical { color: #fff; background: #c62828; padding: 0 4px; }
+details { margin-left: 1em; }
+summary { cursor: pointer; }
+.muted { color: #777; }
+</style>
+</head>
+<body>
+<h1>Threat model</h1>
+<p class="muted">Generated {{.Generated}}</p>
+
+<h2>Summary</h2>
+{{template "counts" .Counts}}
+
+<h2>Data flow diagram</h2>
+<div class="diagram">{{.Diagram}}</div>
+
+<h2>Projects</h2>
+<table>
+<tr><th>Project</th><th>Boundaries</th><th>Components</th><th>Threats</th><th>Mitigations</th><th>Exposures</th><th>Transfers</th><th>Acceptances</th></tr>
+{{range .Projects}}<tr><td><a href="#{{.Anchor}}">{{.Name}}</a></td><td>{{.Counts.Boundaries}}</td><td>{{.Counts.Components}}</td><td>{{.Counts.Threats}}</td><td>{{.Counts.Mitigations}}</td><td>{{.Counts.Exposures}}</td><td>{{.Counts.Transfers}}</td><td>{{.Counts.Acceptances}}</td></tr>
+{{end}}</table>
+
+{{range .Projects}}
+<h2 id="{{.Anchor}}">{{.Name}}</h2>
+{{if .Risks}}<h3>Risks</h3>
+<table>
+<tr><th>Boundary</th><th>Component</th><th>Threat</th><th>Score</th><th>Level</th><th>Exposures</th><th>Mitigations</th></tr>
+{{range .Risks}}<tr><td>{{boundary .Boundary}}</td><td>{{component .Component}}</td><td>{{threat .Threat}}</td><td>{{printf "%.1f" .Score}}</td><td class="{{.Level}}">{{.Level}}</td><td>{{.Exposures}}</td><td>{{.Mitigations}}</td></tr>
+{{end}}</table>{{end}}
+
+<h3>Boundaries</h3>
+{{range .Boundaries}}
+<details open id="{{.Anchor}}">
+<summary><strong>{{.Name}}</strong></summary>
+{{range .Components}}
+<details open id="{{.Anchor}}">
+<summary>{{.Name}}{{if .Status}} <span class="status {{.Status}}">{{.Status}}</span>{{end}}</summary>
+{{range .Threats}}
+<details id="{{.Anchor}}">
+<summary>{{.Name}}{{with .Risk}} <span class="{{.Level}}">{{.Level}} {{printf "%.1f" .Score}}</span>{{end}}</summary>
+{{with .Threat}}{{if .Description}}<p>{{.Description}}</p>{{end}}
+{{if or .Stride .Cwe .Capec .Owasp .Severity .Likelihood}}<p class="muted">{{if .Stride}}STRIDE: {{join .Stride ", "}}. {{end}}{{if .Cwe}}{{join .Cwe ", "}}. {{end}}{{if .Capec}}{{join .Capec ", "}}. {{end}}{{if .Owasp}}OWASP {{.Owasp}}. {{end}}{{if .Severity}}Severity {{.Severity}}. {{end}}{{if .Likelihood}}Likelihood {{.Likelihood}}.{{end}}</p>{{end}}{{end}}
+{{range .Groups}}{{if .Annotations}}
+<h4>{{title .Kind}}s</h4>
+<table>
+<tr><th>{{title .Kind}}</th><th>References</th><th>Source</th></tr>
+{{range .Annotations}}<tr><td>{{.Text}}</td><td>{{join .References ", "}}</td><td>{{with .Source}}{{if .File}}<a href="{{link .}}">{{.File}}:{{.Line}}</a> {{end}}<span class="muted">{{.Function}}</span>{{end}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}
+</details>
+{{end}}
+</details>
+{{end}}
+</details>
+{{end}}
+{{end}}
+</body>
+</html>
+
+{{define "counts"}}<table class="counts">
+<tr><th>Boundaries</th><th>Components</th><th>Threats</th><th>Mitigations</th><th>Exposures</th><th>Transfers</th><th>Acceptances</th></tr>
+<tr><td>{{.Boundaries}}</td><td>{{.Components}}</td><td>{{.Threats}}</td><td>{{.Mitigations}}</td><td>{{.Exposures}}</td><td>{{.Transfers}}</td><td>{{.Acceptances}}</td></tr>
+</table>{{end}}
+`
+
+func main() {
+	outFile := flag.String("out", "threatspec.html", "output HTML file")
+	sourceUrl := flag.String("source-url", "", "base URL of the source tree, such as https://github.com/org/repo/blob/main/, for source links")
+	flag.Parse()
+
+	ts, err := threatspec.LoadFiles(flag.Args())
+	if err != nil {
+		fmt.Println(err)
+		if diagnostics, ok := err.(threatspec.Diagnostics); !ok || diagnostics.HasErrors() {
+			os.Exit(2)
+		}
+	}
+	ts.EnsureScores()
+
+	r := buildReport(ts)
+	funcs := template.FuncMap{
+		"join":      strings.Join,
+		"title":     func(kind string) string { return kindTitles[kind] },
+		"boundary":  ts.BoundaryName,
+		"component": ts.ComponentName,
+		"threat":    ts.ThreatName,
+		"link": func(source *threatspec.Source) string {
+			if source.Line > 0 {
+				return fmt.Sprintf("%s%s#L%d", *sourceUrl, source.File, source.Line)
+			}
+			return *sourceUrl + source.File
+		},
+	}
+	tmpl := template.Must(template.New("report").Funcs(funcs).Parse(reportTemplate))
+
+	htmlFile, err := os.Create(*outFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := tmpl.Execute(htmlFile, r); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := htmlFile.Close(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("HTML report written to %s\n", *outFile)
+	os.Exit(0)
+}
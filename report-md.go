@@ -0,0 +1,293 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/threatspec/threatspec-go/threatspec"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+type markdownReport struct {
+	ts        *threatspec.ThreatSpec
+	sourceUrl string
+}
+
+func cell(text string) string {
+	return strings.Replace(strings.Replace(strings.TrimSpace(text), "|", `\|`, -1), "\n", " ", -1)
+}
+
+// count writes n followed by the singular or plural noun, as in 1 transfer
+// or 2 transfers.
+func count(n int, singular string, plural string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, singular)
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
+
+// source links to where an annotation was found, relative to the repository
+// root unless a source URL was given.
+func (m *markdownReport) source(source *threatspec.Source) string {
+	if source == nil || source.File == "" {
+		return ""
+	}
+	link := fmt.Sprintf("[%s:%d](%s%s#L%d)", source.File, source.Line, m.sourceUrl, source.File, source.Line)
+	if source.Function != "" {
+		link += fmt.Sprintf(" `%s`", source.Function)
+	}
+	return link
+}
+
+// openExposures returns the exposures whose risk is neither mitigated,
+// transferred nor accepted, highest risk first.
+func (m *markdownReport) openExposures() []*threatspec.Annotation {
+	open := make([]*threatspec.Annotation, 0)
+	for _, annotation := range m.ts.Annotations() {
+		if annotation.Kind != "exposure" {
+			continue
+		}
+		risk := m.ts.Risk(annotation.Project, annotation.Boundary, annotation.Component, annotation.Threat)
+		if risk == nil || (risk.Mitigations == 0 && !risk.Transferred && !risk.Accepted) {
+			open = append(open, annotation)
+		}
+	}
+
+	sort.SliceStable(open, func(i, j int) bool { return m.score(open[i]) > m.score(open[j]) })
+	return open
+}
+
+func (m *markdownReport) score(annotation *threatspec.Annotation) float64 {
+	if risk := m.ts.Risk(annotation.Project, annotation.Boundary, annotation.Component, annotation.Threat); risk != nil {
+		return risk.Score
+	}
+	return 0
+}
+
+func (m *markdownReport) exposureRow(annotation *threatspec.Annotation) string {
+	level := ""
+	if risk := m.ts.Risk(annotation.Project, annotation.Boundary, annotation.Component, annotation.Threat); risk != nil {
+		level = fmt.Sprintf("%s %.1f", risk.Level, risk.Score)
+	}
+	return fmt.Sprintf("| %s | %s:%s | %s | %s | %s |\n",
+		level,
+		cell(m.ts.BoundaryName(annotation.Boundary)),
+		cell(m.ts.ComponentName(annotation.Component)),
+		cell(m.ts.ThreatName(annotation.Threat)),
+		cell(annotation.Text),
+		m.source(annotation.Source))
+}
+
+const exposureHeader = "| Risk | Component | Threat | Exposure | Source |\n|---|---|---|---|---|\n"
+
+func (m *markdownReport) counts() string {
+	counts := make(map[string]int)
+	for _, annotation := range m.ts.Annotations() {
+		counts[annotation.Kind]++
+	}
+	return fmt.Sprintf("%s, %s and %s, with %s, %s, %s and %s.\n",
+		count(len(m.ts.Boundaries), "boundary", "boundaries"),
+		count(len(m.ts.Components), "component", "components"),
+		count(len(m.ts.Threats), "threat", "threats"),
+		count(counts["mitigation"], "mitigation", "mitigations"),
+		count(counts["exposure"], "exposure", "exposures"),
+		count(counts["transfer"], "transfer", "transfers"),
+		count(counts["acceptance"], "acceptance", "acceptances"))
+}
+
+// Full renders the whole threat model: the open exposures, then a section
+// for each boundary and component of each project.
+func (m *markdownReport) Full() string {
+	var md strings.Builder
+	md.WriteString("# Threat model\n\n")
+	md.WriteString("<!-- Generated by threatspec-go report-md. Do not edit by hand. -->\n\n")
+	md.WriteString(m.counts())
+
+	md.WriteString("\n## Open exposures\n\n")
+	open := m.openExposures()
+	if len(open) == 0 {
+		md.WriteString("No open exposures.\n")
+	} else {
+		md.WriteString(exposureHeader)
+		for _, annotation := range open {
+			md.WriteString(m.exposureRow(annotation))
+		}
+	}
+
+	annotations := m.ts.Annotations()
+	multiple := len(m.ts.ProjectNames()) > 1
+	for _, projectName := range m.ts.ProjectNames() {
+		byComponent := make(map[threatspec.Id]map[threatspec.Id][]*threatspec.Annotation)
+		for _, annotation := range annotations {
+			if annotation.Project != projectName {
+				continue
+			}
+			if byComponent[annotation.Boundary] == nil {
+				byComponent[annotation.Boundary] = make(map[threatspec.Id][]*threatspec.Annotation)
+			}
+			byComponent[annotation.Boundary][annotation.Component] = append(byComponent[annotation.Boundary][annotation.Component], annotation)
+		}
+		if len(byComponent) == 0 {
+			continue
+		}
+
+		heading := "##"
+		if multiple {
+			fmt.Fprintf(&md, "\n## Project %s\n", projectName)
+			heading = "###"
+		}
+
+		boundaries := make([]threatspec.Id, 0, len(byComponent))
+		for id := range byComponent {
+			boundaries = append(boundaries, id)
+		}
+		sort.Slice(boundaries, func(i, j int) bool { return m.ts.BoundaryName(boundaries[i]) < m.ts.BoundaryName(boundaries[j]) })
+
+		for _, boundary := range boundaries {
+			fmt.Fprintf(&md, "\n%s %s\n", heading, m.ts.BoundaryName(boundary))
+
+			components := make([]threatspec.Id, 0, len(byComponent[boundary]))
+			for id := range byComponent[boundary] {
+				components = append(components, id)
+			}
+			sort.Slice(components, func(i, j int) bool { return m.ts.ComponentName(components[i]) < m.ts.ComponentName(components[j]) })
+
+			for _, component := range components {
+				fmt.Fprintf(&md, "\n%s# %s\n", heading, m.ts.ComponentName(component))
+
+				controls := make([]string, 0)
+				exposures := make([]string, 0)
+				for _, annotation := range byComponent[boundary][component] {
+					if annotation.Kind == "exposure" {
+						exposures = append(exposures, fmt.Sprintf("| %s | %s | %s | %s |\n",
+							cell(m.ts.ThreatName(annotation.Threat)), cell(annotation.Text), cell(strings.Join(annotation.References, ", ")), m.source(annotation.Source)))
+					} else {
+						controls = append(controls, fmt.Sprintf("| %s | %s | %s | %s | %s |\n",
+							cell(m.ts.ThreatName(annotation.Threat)), annotation.Kind, cell(annotation.Text), cell(strings.Join(annotation.References, ", ")), m.source(annotation.Source)))
+					}
+				}
+				sort.Strings(controls)
+				sort.Strings(exposures)
+
+				if len(controls) > 0 {
+					md.WriteString("\n| Threat | Control | Description | References | Source |\n|---|---|---|---|---|\n")
+					md.WriteString(strings.Join(controls, ""))
+				}
+				if len(exposures) > 0 {
+					md.WriteString("\n| Threat | Exposure | References | Source |\n|---|---|---|---|\n")
+					md.WriteString(strings.Join(exposures, ""))
+				}
+			}
+		}
+	}
+
+	return md.String()
+}
+
+// Summary renders the counts and open exposures only, dropping the lowest
+// risks so that the result fits in maxLength bytes, such as the limit on the
+// size of a pull request comment.
+func (m *markdownReport) Summary(maxLength int) string {
+	var md strings.Builder
+	md.WriteString("## Threat model summary\n\n")
+	md.WriteString(m.counts())
+
+	open := m.openExposures()
+	if len(open) == 0 {
+		md.WriteString("\nNo open exposures.\n")
+		return clip(md.String(), maxLength)
+	}
+
+	fmt.Fprintf(&md, "\n### %s\n\n", count(len(open), "open exposure", "open exposures"))
+
+	// Rows are added while they leave room for the note on omitted rows,
+	// unless they are the last, and the note replaces the table when not
+	// even the first row fits
+	var table strings.Builder
+	table.WriteString(exposureHeader)
+	for i, annotation := range open {
+		row := m.exposureRow(annotation)
+		omitted := fmt.Sprintf("\n_%s omitted._\n", count(len(open)-i, "lower risk exposure", "lower risk exposures"))
+		reserve := len(omitted)
+		if i == len(open)-1 {
+			reserve = 0
+		}
+		if md.Len()+table.Len()+len(row)+reserve > maxLength {
+			if i == 0 {
+				omitted = fmt.Sprintf("_%s omitted._\n", count(len(open), "exposure", "exposures"))
+			} else {
+				md.WriteString(table.String())
+			}
+			md.WriteString(omitted)
+			return clip(md.String(), maxLength)
+		}
+		table.WriteString(row)
+	}
+	md.WriteString(table.String())
+
+	return md.String()
+}
+
+// clip cuts text to at most maxLength bytes, without splitting a character,
+// for budgets too small for the counts and the note on omitted rows.
+func clip(text string, maxLength int) string {
+	if len(text) <= maxLength {
+		return text
+	}
+	if maxLength < 0 {
+		return ""
+	}
+	end := maxLength
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return text[:end]
+}
+
+func main() {
+	outFile := flag.String("out", "", "output file, THREATMODEL.md or THREATMODEL-summary.md by default, or - for standard output")
+	summary := flag.Bool("summary", false, "write only the counts and open exposures, for a pull request comment")
+	maxLength := flag.Int("max-length", 65000, "maximum size in bytes of the summary")
+	sourceUrl := flag.String("source-url", "", "base URL of the source tree for source links, which are otherwise relative to the repository root")
+	flag.Parse()
+
+	ts, err := threatspec.LoadFiles(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if diagnostics, ok := err.(threatspec.Diagnostics); !ok || diagnostics.HasErrors() {
+			os.Exit(2)
+		}
+	}
+	ts.EnsureScores()
+
+	m := &markdownReport{ts: ts, sourceUrl: *sourceUrl}
+	content := ""
+	if *summary {
+		content = m.Summary(*maxLength)
+		if *outFile == "" {
+			*outFile = "THREATMODEL-summary.md"
+		}
+	} else {
+		content = m.Full()
+		if *outFile == "" {
+			*outFile = "THREATMODEL.md"
+		}
+	}
+
+	if *outFile == "-" {
+		fmt.Print(content)
+		os.Exit(0)
+	}
+
+	if err := ioutil.WriteFile(*outFile, []byte(content), 0644); err != nil {
+		fmt.Println("Error writing file")
+		fmt.Println(err)
+		os.Exit(3)
+	}
+
+	fmt.Printf("Markdown report written to %s\n", *outFile)
+	os.Exit(0)
+}
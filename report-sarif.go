@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/threatspec/threatspec-go/threatspec"
+	"io/ioutil"
+	"os"
+)
+
+func main() {
+	project := flag.String("project", "default", "project name for annotations parsed from source")
+	outFile := flag.String("out", "threatspec.sarif", "output SARIF file")
+	flag.Parse()
+
+	// Inputs may be JSON specs or source to parse, so that annotation parse
+	// errors are reported alongside the exposures
+	ts := threatspec.New(*project)
+	var diagnostics threatspec.Diagnostics
+	if err := ts.Parse(flag.Args()); err != nil {
+		var ok bool
+		if diagnostics, ok = err.(threatspec.Diagnostics); !ok {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	}
+	ts.EnsureScores()
+
+	if err := ioutil.WriteFile(*outFile, []byte(ts.ToSarif(diagnostics).ToJson()), 0644); err != nil {
+		fmt.Println("Error writing file")
+		fmt.Println(err)
+		os.Exit(3)
+	}
+
+	fmt.Printf("SARIF written to %s\n", *outFile)
+	os.Exit(0)
+}
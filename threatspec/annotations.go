@@ -0,0 +1,75 @@
+package threatspec
+
+import (
+	"sort"
+)
+
+// Annotation is a mitigation, exposure, transfer or acceptance together with
+// the project and id it is recorded under, for code that treats all four
+// alike. Kind is mitigation, exposure, transfer or acceptance, Text the
+// mitigation, exposure, transfer or acceptance itself, and Value the
+// underlying *Mitigation, *Exposure, *Transfer or *Acceptance.
+type Annotation struct {
+	Project    string
+	Kind       string
+	Id         Id
+	Text       string
+	Boundary   Id
+	Component  Id
+	Threat     Id
+	References []string
+	Source     *Source
+	Value      interface{}
+}
+
+// Annotations returns every annotation in the spec, ordered by project, kind
+// and id, then in the order they were found.
+func (ts *ThreatSpec) Annotations() []*Annotation {
+	annotations := make([]*Annotation, 0)
+
+	for _, projectName := range ts.ProjectNames() {
+		project := ts.Projects[projectName]
+		if project == nil {
+			continue
+		}
+
+		for id, ms := range project.Mitigations {
+			for _, m := range ms {
+				annotations = append(annotations, &Annotation{projectName, "mitigation", id, m.Mitigation,
+					m.Boundary, m.Component, m.Threat, m.References, m.Source, m})
+			}
+		}
+		for id, es := range project.Exposures {
+			for _, e := range es {
+				annotations = append(annotations, &Annotation{projectName, "exposure", id, e.Exposure,
+					e.Boundary, e.Component, e.Threat, e.References, e.Source, e})
+			}
+		}
+		for id, trs := range project.Transfers {
+			for _, t := range trs {
+				annotations = append(annotations, &Annotation{projectName, "transfer", id, t.Transfer,
+					t.Boundary, t.Component, t.Threat, t.References, t.Source, t})
+			}
+		}
+		for id, as := range project.Acceptances {
+			for _, a := range as {
+				annotations = append(annotations, &Annotation{projectName, "acceptance", id, a.Acceptance,
+					a.Boundary, a.Component, a.Threat, a.References, a.Source, a})
+			}
+		}
+	}
+
+	// Annotations under one id keep their order, as maps are visited randomly
+	sort.SliceStable(annotations, func(i, j int) bool {
+		a, b := annotations[i], annotations[j]
+		if a.Project != b.Project {
+			return a.Project < b.Project
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Id < b.Id
+	})
+
+	return annotations
+}
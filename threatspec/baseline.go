@@ -0,0 +1,187 @@
+package threatspec
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Fingerprint identifies an annotation by its project, kind, boundary,
+// component, threat and function, so that it survives unrelated edits that
+// move it to another line. Annotations without a source function, such as
+// those from .threatspec files, are identified by the rest alone.
+func Fingerprint(project string, kind string, boundary, component, threat Id, source *Source) string {
+	function := ""
+	if source != nil {
+		function = source.Function
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join([]string{project, kind, string(boundary), string(component), string(threat), function}, "|")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (a *Annotation) Fingerprint() string {
+	return Fingerprint(a.Project, a.Kind, a.Boundary, a.Component, a.Threat, a.Source)
+}
+
+// Finding is an exposure recorded in a baseline.
+type Finding struct {
+	Fingerprint string `json:"fingerprint"`
+	Project     string `json:"project"`
+	Boundary    Id     `json:"boundary"`
+	Component   Id     `json:"component"`
+	Threat      Id     `json:"threat"`
+	Function    string `json:"function,omitempty"`
+	Exposure    string `json:"exposure"`
+}
+
+// Suppression silences a finding until Expires, a YYYY-MM-DD date, or for
+// good when Expires is empty.
+type Suppression struct {
+	Fingerprint   string `json:"fingerprint"`
+	Justification string `json:"justification"`
+	Expires       string `json:"expires,omitempty"`
+}
+
+// Baseline is a snapshot of the exposures known when it was taken, together
+// with suppressions maintained by hand.
+type Baseline struct {
+	Created      int64          `json:"created"`
+	Findings     []*Finding     `json:"findings"`
+	Suppressions []*Suppression `json:"suppressions,omitempty"`
+}
+
+// NewBaseline snapshots the exposures in ts. Exposures sharing a fingerprint
+// are recorded once.
+func NewBaseline(ts *ThreatSpec) *Baseline {
+	baseline := &Baseline{
+		Created:  time.Now().Unix(),
+		Findings: make([]*Finding, 0),
+	}
+
+	seen := make(map[string]bool)
+	for _, annotation := range ts.Annotations() {
+		fingerprint := annotation.Fingerprint()
+		if annotation.Kind != "exposure" || seen[fingerprint] {
+			continue
+		}
+		seen[fingerprint] = true
+
+		finding := &Finding{
+			Fingerprint: fingerprint,
+			Project:     annotation.Project,
+			Boundary:    annotation.Boundary,
+			Component:   annotation.Component,
+			Threat:      annotation.Threat,
+			Exposure:    annotation.Text,
+		}
+		if annotation.Source != nil {
+			finding.Function = annotation.Source.Function
+		}
+		baseline.Findings = append(baseline.Findings, finding)
+	}
+
+	sort.Slice(baseline.Findings, func(i, j int) bool {
+		return baseline.Findings[i].Fingerprint < baseline.Findings[j].Fingerprint
+	})
+	return baseline
+}
+
+// LoadBaseline reads a baseline file, checking that every suppression has a
+// justification and a valid expiry date.
+func LoadBaseline(filename string) (*Baseline, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	baseline := new(Baseline)
+	if err := json.Unmarshal(content, baseline); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	for _, suppression := range baseline.Suppressions {
+		if suppression.Fingerprint == "" || strings.TrimSpace(suppression.Justification) == "" {
+			return nil, fmt.Errorf("%s: suppressions need a fingerprint and a justification", filename)
+		}
+		if _, err := suppression.expiry(); err != nil {
+			return nil, fmt.Errorf("%s: suppression %s: invalid expiry date %q", filename, suppression.Fingerprint, suppression.Expires)
+		}
+	}
+	return baseline, nil
+}
+
+func (b *Baseline) ToJson() string {
+	dump, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(dump)
+}
+
+// expiry returns the end of the suppression's expiry day, or the zero time
+// when it never expires.
+func (s *Suppression) expiry() (time.Time, error) {
+	if s.Expires == "" {
+		return time.Time{}, nil
+	}
+	date, err := time.Parse("2006-01-02", s.Expires)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return date.AddDate(0, 0, 1), nil
+}
+
+// Expired reports whether the suppression no longer applies at now.
+func (s *Suppression) Expired(now time.Time) bool {
+	expiry, err := s.expiry()
+	return err != nil || (!expiry.IsZero() && !now.Before(expiry))
+}
+
+// Suppression returns the suppression for fingerprint, if any, whether or
+// not it has expired.
+func (b *Baseline) Suppression(fingerprint string) *Suppression {
+	for _, suppression := range b.Suppressions {
+		if suppression.Fingerprint == fingerprint {
+			return suppression
+		}
+	}
+	return nil
+}
+
+// Covers reports whether a finding with fingerprint is in the baseline or
+// suppressed at now, so that it should not fail a build.
+func (b *Baseline) Covers(fingerprint string, now time.Time) bool {
+	if suppression := b.Suppression(fingerprint); suppression != nil && !suppression.Expired(now) {
+		return true
+	}
+	for _, finding := range b.Findings {
+		if finding.Fingerprint == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// Removed returns the findings in the baseline that ts no longer exposes.
+func (b *Baseline) Removed(ts *ThreatSpec) []*Finding {
+	current := make(map[string]bool)
+	for _, annotation := range ts.Annotations() {
+		if annotation.Kind == "exposure" {
+			current[annotation.Fingerprint()] = true
+		}
+	}
+
+	removed := make([]*Finding, 0)
+	for _, finding := range b.Findings {
+		if !current[finding.Fingerprint] {
+			removed = append(removed, finding)
+		}
+	}
+	return removed
+}
@@ -0,0 +1,65 @@
+package threatspec
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	source := &Source{Function: "main.editHandler", File: "simple.go", Line: 54}
+	moved := &Source{Function: "main.editHandler", File: "simple.go", Line: 90}
+	base := Fingerprint("wiki", "exposure", "@webapp", "@app", "@xss", source)
+
+	if Fingerprint("wiki", "exposure", "@webapp", "@app", "@xss", moved) != base {
+		t.Error("fingerprint changed when the annotation moved to another line")
+	}
+
+	different := map[string]string{
+		"project":   Fingerprint("blog", "exposure", "@webapp", "@app", "@xss", source),
+		"kind":      Fingerprint("wiki", "mitigation", "@webapp", "@app", "@xss", source),
+		"boundary":  Fingerprint("wiki", "exposure", "@api", "@app", "@xss", source),
+		"component": Fingerprint("wiki", "exposure", "@webapp", "@db", "@xss", source),
+		"threat":    Fingerprint("wiki", "exposure", "@webapp", "@app", "@sqli", source),
+		"function":  Fingerprint("wiki", "exposure", "@webapp", "@app", "@xss", nil),
+	}
+	for field, fingerprint := range different {
+		if fingerprint == base {
+			t.Errorf("fingerprint ignores the %s", field)
+		}
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	ts := New("wiki")
+	exposure := &Exposure{Exposure: "raw output", Boundary: "@webapp", Component: "@app", Threat: "@xss",
+		Source: &Source{Function: "main.editHandler"}}
+	ts.AddExposure("@raw_output", exposure)
+	ts.AddProject("blog").AddExposure("@raw_output", exposure)
+
+	baseline := NewBaseline(ts)
+	if len(baseline.Findings) != 2 {
+		t.Fatalf("baseline of the same exposure in two projects has %d findings, want 2", len(baseline.Findings))
+	}
+
+	wiki := Fingerprint("wiki", "exposure", "@webapp", "@app", "@xss", exposure.Source)
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	baseline.Findings = baseline.Findings[:0]
+	baseline.Suppressions = []*Suppression{
+		{Fingerprint: wiki, Justification: "escaped by the framework", Expires: "2026-06-01"},
+	}
+
+	if !baseline.Covers(wiki, now) {
+		t.Error("suppression does not cover its finding on its expiry date")
+	}
+	if baseline.Covers(wiki, now.AddDate(0, 0, 1)) {
+		t.Error("expired suppression still covers its finding")
+	}
+	if baseline.Covers(Fingerprint("blog", "exposure", "@webapp", "@app", "@xss", exposure.Source), now) {
+		t.Error("suppression in one project covers the same exposure in another")
+	}
+
+	previous := &Baseline{Findings: []*Finding{{Fingerprint: wiki}, {Fingerprint: "0000000000000000"}}}
+	if removed := previous.Removed(ts); len(removed) != 1 || removed[0].Fingerprint != "0000000000000000" {
+		t.Errorf("Removed = %+v, want only the finding no longer exposed", removed)
+	}
+}
@@ -0,0 +1,256 @@
+package threatspec
+
+import (
+	"fmt"
+	"go/types"
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/callgraph/cha"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+	"sort"
+	"strings"
+)
+
+// ssaFunctionName renders an SSA function the same way Function.FullName does,
+// so call graph nodes can be matched against annotation sources.
+func ssaFunctionName(fn *ssa.Function) string {
+	if origin := fn.Origin(); origin != nil {
+		fn = origin
+	}
+
+	if parent := fn.Parent(); parent != nil {
+		for i, anon := range parent.AnonFuncs {
+			if anon == fn {
+				return fmt.Sprintf("%s.func%d", ssaFunctionName(parent), i+1)
+			}
+		}
+	}
+
+	pkgPath := ""
+	if fn.Pkg != nil {
+		pkgPath = fn.Pkg.Pkg.Path()
+	} else if obj, ok := fn.Object().(*types.Func); ok && obj.Pkg() != nil {
+		pkgPath = obj.Pkg().Path()
+	}
+
+	if recv := fn.Signature.Recv(); recv != nil {
+		recvType := recv.Type()
+		pointer := false
+		if ptr, ok := recvType.(*types.Pointer); ok {
+			recvType = ptr.Elem()
+			pointer = true
+		}
+		unqualified := func(*types.Package) string { return "" }
+		typeName := types.TypeString(recvType, unqualified)
+		if named, ok := recvType.(*types.Named); ok {
+			typeName = named.Obj().Name()
+			if args := named.TypeArgs(); args.Len() > 0 {
+				params := make([]string, 0, args.Len())
+				for i := 0; i < args.Len(); i++ {
+					params = append(params, types.TypeString(args.At(i), unqualified))
+				}
+				typeName += "[" + strings.Join(params, ",") + "]"
+			}
+		}
+		if pointer {
+			typeName = "(*" + typeName + ")"
+		}
+		return fmt.Sprintf("%s.%s.%s", pkgPath, typeName, fn.Name())
+	}
+
+	return fmt.Sprintf("%s.%s", pkgPath, fn.Name())
+}
+
+// annotatedFunctions returns the names of all functions that carry at least
+// one mitigation, exposure, transfer or acceptance. Sources written before
+// Kind was recorded are assumed to be functions.
+func (ts *ThreatSpec) annotatedFunctions() map[string]bool {
+	annotated := make(map[string]bool)
+	add := func(source *Source) {
+		if source != nil && source.Function != "" && (source.Kind == "" || source.Kind == "function") {
+			annotated[source.Function] = true
+		}
+	}
+
+	for _, project := range ts.Projects {
+		for _, ms := range project.Mitigations {
+			for _, m := range ms {
+				add(m.Source)
+			}
+		}
+		for _, es := range project.Exposures {
+			for _, e := range es {
+				add(e.Source)
+			}
+		}
+		for _, trs := range project.Transfers {
+			for _, t := range trs {
+				add(t.Source)
+			}
+		}
+		for _, as := range project.Acceptances {
+			for _, a := range as {
+				add(a.Source)
+			}
+		}
+	}
+
+	return annotated
+}
+
+// illTypedDiagnostic warns that pkg is left out of the call flow, giving its
+// first type error.
+func illTypedDiagnostic(pkg *packages.Package) *Diagnostic {
+	diagnostic := &Diagnostic{Severity: SeverityWarning, Message: fmt.Sprintf("package %s does not type check, so its calls are left out of the call flow", pkg.PkgPath)}
+	if len(pkg.GoFiles) > 0 {
+		diagnostic.File = displayPath(pkg.GoFiles[0])
+	}
+	if len(pkg.Errors) > 0 {
+		first := ToDiagnostics(diagnostic.File, pkg.Errors[0])[0]
+		diagnostic.File, diagnostic.Line, diagnostic.Column = first.File, first.Line, first.Column
+		diagnostic.Message += ": " + first.Message
+	} else if len(pkg.TypeErrors) > 0 {
+		position := pkg.Fset.Position(pkg.TypeErrors[0].Pos)
+		diagnostic.File, diagnostic.Line, diagnostic.Column = displayPath(position.Filename), position.Line, position.Column
+		diagnostic.Message += ": " + pkg.TypeErrors[0].Msg
+	}
+	return diagnostic
+}
+
+// buildProgram builds SSA for the packages that type check, warning about
+// those that do not.
+func buildProgram(pkgs []*packages.Package) (*ssa.Program, Diagnostics) {
+	if len(pkgs) == 0 {
+		return nil, nil
+	}
+
+	prog := ssa.NewProgram(pkgs[0].Fset, ssa.InstantiateGenerics)
+	created := make(map[*types.Package]bool)
+	diagnostics := make(Diagnostics, 0)
+	warned := make(map[string]bool)
+
+	for _, pkg := range pkgs {
+		if pkg.Types == nil || strings.HasSuffix(pkg.PkgPath, ".test") || created[pkg.Types] {
+			continue
+		}
+		if pkg.IllTyped {
+			if !warned[pkg.PkgPath] {
+				warned[pkg.PkgPath] = true
+				diagnostics = append(diagnostics, illTypedDiagnostic(pkg))
+			}
+			continue
+		}
+		created[pkg.Types] = true
+		prog.CreatePackage(pkg.Types, pkg.Syntax, pkg.TypesInfo, true)
+	}
+
+	// Dependencies only need their members, not function bodies
+	var createImports func(imports []*types.Package)
+	createImports = func(imports []*types.Package) {
+		for _, imported := range imports {
+			if !created[imported] {
+				created[imported] = true
+				prog.CreatePackage(imported, nil, nil, true)
+				createImports(imported.Imports())
+			}
+		}
+	}
+	for _, pkg := range pkgs {
+		if pkg.Types != nil && created[pkg.Types] {
+			createImports(pkg.Types.Imports())
+		}
+	}
+
+	prog.Build()
+	return prog, diagnostics
+}
+
+// BuildCallFlow populates CallFlow with the calls between annotated functions.
+// Calls made through unannotated functions are collapsed, so an edge means
+// the destination is reachable from the source without passing through any
+// other annotated function. Closures that carry no annotations of their own
+// are attributed to the function that encloses them. Packages must have been
+// loaded with Options.CallFlow set. Packages that do not type check are left
+// out, with a warning for each.
+func (ts *ThreatSpec) BuildCallFlow(pkgs []*packages.Package) Diagnostics {
+	prog, diagnostics := buildProgram(pkgs)
+	if prog == nil {
+		return diagnostics
+	}
+
+	annotated := ts.annotatedFunctions()
+	graph := cha.CallGraph(prog)
+	graph.DeleteSyntheticNodes()
+
+	owners := make(map[*ssa.Function]string)
+	var owner func(fn *ssa.Function) string
+	owner = func(fn *ssa.Function) string {
+		if name, ok := owners[fn]; ok {
+			return name
+		}
+		name := ssaFunctionName(fn)
+		if !annotated[name] {
+			name = ""
+			if fn.Parent() != nil {
+				name = owner(fn.Parent())
+			}
+		}
+		owners[fn] = name
+		return name
+	}
+
+	// Group the graph nodes by the annotated function that owns them
+	sources := make(map[string][]*callgraph.Node)
+	for fn, node := range graph.Nodes {
+		if fn == nil {
+			continue
+		}
+		if name := owner(fn); name != "" {
+			sources[name] = append(sources[name], node)
+		}
+	}
+
+	edges := make(map[Call]bool)
+	for source, nodes := range sources {
+		visited := make(map[*callgraph.Node]bool)
+		queue := append([]*callgraph.Node{}, nodes...)
+		for _, node := range nodes {
+			visited[node] = true
+		}
+
+		for len(queue) > 0 {
+			node := queue[0]
+			queue = queue[1:]
+
+			for _, edge := range node.Out {
+				callee := edge.Callee
+				if visited[callee] {
+					continue
+				}
+				visited[callee] = true
+
+				destination := owner(callee.Func)
+				if destination != "" && destination != source {
+					edges[Call{Source: source, Destination: destination}] = true
+					continue
+				}
+				queue = append(queue, callee)
+			}
+		}
+	}
+
+	calls := make([]*Call, 0, len(edges))
+	for edge := range edges {
+		call := edge
+		calls = append(calls, &call)
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		if calls[i].Source != calls[j].Source {
+			return calls[i].Source < calls[j].Source
+		}
+		return calls[i].Destination < calls[j].Destination
+	})
+
+	ts.CallFlow = append(ts.CallFlow, calls...)
+	return diagnostics
+}
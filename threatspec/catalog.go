@@ -0,0 +1,195 @@
+package threatspec
+
+import (
+	"embed"
+	"encoding/csv"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The catalog covers the CWE Top 25 and other weaknesses and attack patterns
+// commonly seen in threat models, rather than the full MITRE lists. Names and
+// descriptions come from MITRE's XML downloads through catalog/generate.go,
+// which records the version in a comment on the first line of each file.
+//
+//go:generate go run catalog/generate.go
+//go:embed catalog/*.csv
+var catalogFiles embed.FS
+
+var catalogUrls = map[string]string{
+	"CWE":   "https://cwe.mitre.org/data/definitions/%s.html",
+	"CAPEC": "https://capec.mitre.org/data/definitions/%s.html",
+}
+
+var catalogRefPattern = regexp.MustCompile(`(?i)^@?(?P<prefix>cwe|capec)[-_ ]?(?P<number>[0-9]+)$`)
+
+// CatalogEntry is a CWE weakness or CAPEC attack pattern from the embedded
+// catalog. Id is written as CWE-79 or CAPEC-63.
+type CatalogEntry struct {
+	Id          string
+	Name        string
+	Description string
+	Url         string
+}
+
+var catalog struct {
+	sync.Once
+	entries []*CatalogEntry
+	byId    map[string]*CatalogEntry
+}
+
+func loadCatalog() {
+	catalog.byId = make(map[string]*CatalogEntry)
+
+	for _, name := range []string{"catalog/cwe.csv", "catalog/capec.csv"} {
+		file, err := catalogFiles.Open(name)
+		if err != nil {
+			panic(err)
+		}
+		reader := csv.NewReader(file)
+		reader.Comment = '#'
+		records, err := reader.ReadAll()
+		file.Close()
+		if err != nil {
+			panic(fmt.Sprintf("%s: %s", name, err))
+		}
+
+		// The first record is the header
+		for _, record := range records[1:] {
+			prefix, number := catalogId(record[0])
+			entry := &CatalogEntry{
+				Id:          record[0],
+				Name:        record[1],
+				Description: record[2],
+				Url:         fmt.Sprintf(catalogUrls[prefix], number),
+			}
+			catalog.entries = append(catalog.entries, entry)
+			catalog.byId[entry.Id] = entry
+		}
+	}
+
+	sort.Slice(catalog.entries, func(i, j int) bool {
+		pi, ni := catalogId(catalog.entries[i].Id)
+		pj, nj := catalogId(catalog.entries[j].Id)
+		if pi != pj {
+			return pi > pj // CWE before CAPEC
+		}
+		a, _ := strconv.Atoi(ni)
+		b, _ := strconv.Atoi(nj)
+		return a < b
+	})
+}
+
+// catalogId splits a reference such as CWE-79, cwe_79 or @cwe_79 into its
+// upper case prefix and number, or returns empty strings.
+func catalogId(reference string) (string, string) {
+	m := catalogRefPattern.FindStringSubmatch(strings.TrimSpace(reference))
+	if m == nil {
+		return "", ""
+	}
+	return strings.ToUpper(m[1]), strings.TrimLeft(m[2], "0")
+}
+
+// Catalog returns every entry in the embedded catalog, CWE entries first.
+func Catalog() []*CatalogEntry {
+	catalog.Do(loadCatalog)
+	return catalog.entries
+}
+
+// LookupCatalog returns the catalog entry for a reference written as CWE-79,
+// cwe 79, @cwe_79 or @cwe79, or nil if there is none.
+func LookupCatalog(reference string) *CatalogEntry {
+	catalog.Do(loadCatalog)
+	prefix, number := catalogId(reference)
+	if prefix == "" {
+		return nil
+	}
+	return catalog.byId[prefix+"-"+number]
+}
+
+// SearchCatalog returns the entries whose id, name or description contains
+// every word of query, ignoring case.
+func SearchCatalog(query string) []*CatalogEntry {
+	words := strings.Fields(strings.ToLower(query))
+	results := make([]*CatalogEntry, 0)
+
+	for _, entry := range Catalog() {
+		text := strings.ToLower(entry.Id + " " + entry.Name + " " + entry.Description)
+		matches := true
+		for _, word := range words {
+			if !strings.Contains(text, word) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			results = append(results, entry)
+		}
+	}
+
+	return results
+}
+
+// ThreatId returns the id threats resolved from the entry are added under,
+// such as @cwe_79.
+func (e *CatalogEntry) ThreatId() Id {
+	return Id("@" + strings.ToLower(strings.Replace(e.Id, "-", "_", 1)))
+}
+
+func (e *CatalogEntry) ToThreat() *Threat {
+	threat := &Threat{
+		Name:        e.Name,
+		Description: e.Description,
+		References:  []string{e.Url},
+	}
+	if strings.HasPrefix(e.Id, "CWE-") {
+		threat.Cwe = []string{e.Id}
+	} else {
+		threat.Capec = []string{e.Id}
+	}
+	return threat
+}
+
+// catalogDiagnostic warns about a mitigation, exposure, transfer or
+// acceptance on line whose threat is a CWE or CAPEC reference missing from
+// the catalog, as it is then added as a threat named after the reference.
+// A catalog id one edit away is suggested.
+func (ts *ThreatSpec) catalogDiagnostic(file string, line commentLine, kind string) *Diagnostic {
+	form, ok := annotationForms[kind]
+	if !ok || kind == "alias" {
+		return nil
+	}
+	m := ts.matchLine(line.text, form.pattern)
+	if m == nil {
+		return nil
+	}
+	prefix, number := catalogId(m["threat"])
+	if prefix == "" || LookupCatalog(m["threat"]) != nil {
+		return nil
+	}
+
+	reference := prefix + "-" + number
+	diagnostic := &Diagnostic{
+		Severity: SeverityWarning,
+		File:     file,
+		Line:     line.line,
+		Column:   line.column + strings.Index(line.text, m["threat"]),
+		Kind:     kind,
+		Message:  fmt.Sprintf("%s is not in the CWE and CAPEC catalog, so it has no name or description", reference),
+	}
+
+	ids := make([]string, 0)
+	for _, entry := range Catalog() {
+		if strings.HasPrefix(entry.Id, prefix+"-") {
+			ids = append(ids, entry.Id)
+		}
+	}
+	if id, ok := nearMiss(reference, ids); ok {
+		diagnostic.Suggestion = strings.Replace(strings.TrimSpace(line.text), m["threat"], id, 1)
+	}
+	return diagnostic
+}
@@ -0,0 +1,168 @@
+//go:build ignore
+
+// Generate rewrites cwe.csv and capec.csv from MITRE's CWE and CAPEC XML
+// downloads, keeping the ids already listed in each file. The name and
+// description of every entry are MITRE's, and the first line of each file
+// records the version and date of the download. Run it from the threatspec
+// directory with go generate, or give downloaded copies of the files with
+// -cwe and -capec.
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+type catalogEntry struct {
+	Id          string `xml:"ID,attr"`
+	Name        string `xml:"Name,attr"`
+	Description struct {
+		Text string `xml:",innerxml"`
+	} `xml:"Description"`
+}
+
+type catalogDocument struct {
+	Name           string         `xml:"Name,attr"`
+	Version        string         `xml:"Version,attr"`
+	Date           string         `xml:"Date,attr"`
+	Weaknesses     []catalogEntry `xml:"Weaknesses>Weakness"`
+	AttackPatterns []catalogEntry `xml:"Attack_Patterns>Attack_Pattern"`
+}
+
+// read returns the contents of a URL or file, unzipping the first XML file
+// of a zip archive.
+func read(location string) ([]byte, error) {
+	var content []byte
+	var err error
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		var response *http.Response
+		if response, err = http.Get(location); err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", location, response.Status)
+		}
+		content, err = ioutil.ReadAll(response.Body)
+	} else {
+		content, err = ioutil.ReadFile(location)
+	}
+	if err != nil || !strings.HasSuffix(location, ".zip") {
+		return content, err
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range archive.File {
+		if strings.HasSuffix(file.Name, ".xml") {
+			f, err := file.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			return ioutil.ReadAll(f)
+		}
+	}
+	return nil, fmt.Errorf("%s: no XML file in archive", location)
+}
+
+// text flattens a description, which may hold XHTML, to a single line.
+func text(description string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(tagPattern.ReplaceAllString(description, " "))), " ")
+}
+
+// ids returns the ids listed in an existing catalog file.
+func ids(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	// The first record is the header
+	list := make([]string, 0, len(records))
+	for _, record := range records[1:] {
+		list = append(list, record[0])
+	}
+	return list, nil
+}
+
+func generate(filename string, location string, prefix string) error {
+	content, err := read(location)
+	if err != nil {
+		return err
+	}
+	var document catalogDocument
+	if err := xml.Unmarshal(content, &document); err != nil {
+		return fmt.Errorf("%s: %s", location, err)
+	}
+
+	entries := make(map[string]catalogEntry)
+	for _, entry := range append(document.Weaknesses, document.AttackPatterns...) {
+		entries[prefix+"-"+entry.Id] = entry
+	}
+
+	wanted, err := ids(filename)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "# Generated by generate.go from %s version %s (%s). DO NOT EDIT.\n", document.Name, document.Version, document.Date)
+	writer := csv.NewWriter(&out)
+	writer.Write([]string{"id", "name", "description"})
+	for _, id := range wanted {
+		entry, ok := entries[id]
+		if !ok {
+			return fmt.Errorf("%s is not in %s version %s", id, document.Name, document.Version)
+		}
+		writer.Write([]string{id, entry.Name, text(entry.Description.Text)})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, out.Bytes(), 0644)
+}
+
+func main() {
+	cwe := flag.String("cwe", "https://cwe.mitre.org/data/xml/cwec_latest.xml.zip", "CWE XML download, as a URL or file")
+	capec := flag.String("capec", "https://capec.mitre.org/data/xml/capec_latest.xml", "CAPEC XML download, as a URL or file")
+	dir := flag.String("dir", "catalog", "directory holding cwe.csv and capec.csv")
+	flag.Parse()
+
+	status := 0
+	for _, catalog := range []struct{ filename, location, prefix string }{
+		{"cwe.csv", *cwe, "CWE"},
+		{"capec.csv", *capec, "CAPEC"},
+	} {
+		if err := generate(filepath.Join(*dir, catalog.filename), catalog.location, catalog.prefix); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
@@ -0,0 +1,498 @@
+package threatspec
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Component statuses, from the annotations concerning a component: exposed
+// to a threat that is not mitigated, transferred or accepted; exposed but
+// only through threats that were transferred or accepted; or mitigated.
+// Components with none of these, such as those threats are transferred to,
+// have no status.
+const (
+	StatusExposed   = "exposed"
+	StatusAccepted  = "accepted"
+	StatusMitigated = "mitigated"
+	StatusNone      = ""
+)
+
+// FlowNode is a component within a boundary, or a function that transfers
+// threats without belonging to any component, which has no Boundary or
+// Component and is named after the function.
+type FlowNode struct {
+	Id        string
+	Boundary  Id
+	Component Id
+	Name      string
+	Status    string
+	Threats   []string // names of the threats the component is exposed to
+}
+
+// FlowBoundary is a trust boundary and the components annotated within it.
+// Functions that belong to no component are gathered in a boundary with no
+// Id, named Functions.
+type FlowBoundary struct {
+	Id    Id
+	Name  string
+	Nodes []*FlowNode
+}
+
+// FlowEdge is a call between components, or a transfer of a threat from the
+// components of the transferring function to another component. Kind is
+// call or transfer, and Label the threat transferred.
+type FlowEdge struct {
+	From  string
+	To    string
+	Kind  string
+	Label string
+}
+
+// DataFlow is the data flow diagram of a spec, drawn from the boundaries and
+// components of its annotations, its call flow and its transfers.
+type DataFlow struct {
+	Boundaries []*FlowBoundary
+	Edges      []*FlowEdge
+}
+
+func flowNodeId(boundary, component Id) string {
+	return strings.TrimPrefix(string(boundary), "@") + ":" + strings.TrimPrefix(string(component), "@")
+}
+
+// DataFlow builds the data flow diagram of every project in ts. Functions are
+// placed in the components they mitigate, expose or accept threats for, so
+// that calls between annotated functions become edges between components.
+func (ts *ThreatSpec) DataFlow() *DataFlow {
+	flow := &DataFlow{
+		Boundaries: make([]*FlowBoundary, 0),
+		Edges:      make([]*FlowEdge, 0),
+	}
+
+	nodes := make(map[string]*FlowNode)
+	node := func(boundary, component Id) *FlowNode {
+		id := flowNodeId(boundary, component)
+		if nodes[id] == nil {
+			nodes[id] = &FlowNode{
+				Id:        id,
+				Boundary:  boundary,
+				Component: component,
+				Name:      ts.ComponentName(component),
+				Threats:   make([]string, 0),
+			}
+		}
+		return nodes[id]
+	}
+
+	// A node's status is the worst of its risks
+	rank := map[string]int{StatusNone: 0, StatusMitigated: 1, StatusAccepted: 2, StatusExposed: 3}
+	for _, projectName := range ts.ProjectNames() {
+		for _, input := range ts.riskInputs(ts.Projects[projectName]) {
+			n := node(input.Boundary, input.Component)
+			status := StatusNone
+			switch {
+			case input.Exposures > 0 && input.Mitigations+input.Transfers+input.Acceptances == 0:
+				status = StatusExposed
+			case input.Exposures > 0 && input.Mitigations == 0:
+				status = StatusAccepted
+			case input.Mitigations > 0:
+				status = StatusMitigated
+			}
+			if rank[status] > rank[n.Status] {
+				n.Status = status
+			}
+			if input.Exposures > 0 {
+				n.Threats = appendMissing(n.Threats, ts.ThreatName(input.Threat))
+			}
+		}
+	}
+
+	// Transfers name the component a threat is handed to, not the one the
+	// function belongs to
+	functions := make(map[string][]string)
+	annotations := ts.Annotations()
+	for _, annotation := range annotations {
+		if annotation.Kind == "transfer" || annotation.Source == nil || annotation.Source.Function == "" {
+			continue
+		}
+		function := annotation.Source.Function
+		functions[function] = appendMissing(functions[function], flowNodeId(annotation.Boundary, annotation.Component))
+	}
+
+	// A function that only transfers threats is a node of its own, so that
+	// its transfers and calls are still drawn. Function names hold no colon,
+	// so they cannot clash with the ids of components.
+	for _, annotation := range annotations {
+		if annotation.Kind != "transfer" || annotation.Source == nil || annotation.Source.Function == "" {
+			continue
+		}
+		function := annotation.Source.Function
+		if len(functions[function]) == 0 {
+			nodes[function] = &FlowNode{Id: function, Name: function, Threats: make([]string, 0)}
+			functions[function] = []string{function}
+		}
+	}
+
+	seen := make(map[FlowEdge]bool)
+	edge := func(from, to, kind, label string) {
+		e := FlowEdge{from, to, kind, label}
+		if from != to && !seen[e] {
+			seen[e] = true
+			flow.Edges = append(flow.Edges, &e)
+		}
+	}
+
+	for _, call := range ts.CallFlow {
+		for _, from := range functions[call.Source] {
+			for _, to := range functions[call.Destination] {
+				edge(from, to, "call", "")
+			}
+		}
+	}
+	for _, annotation := range annotations {
+		if annotation.Kind != "transfer" || annotation.Source == nil {
+			continue
+		}
+		to := node(annotation.Boundary, annotation.Component).Id
+		for _, from := range functions[annotation.Source.Function] {
+			edge(from, to, "transfer", ts.ThreatName(annotation.Threat))
+		}
+	}
+
+	boundaries := make(map[Id]*FlowBoundary)
+	for _, n := range nodes {
+		if boundaries[n.Boundary] == nil {
+			boundaries[n.Boundary] = &FlowBoundary{Id: n.Boundary, Name: ts.BoundaryName(n.Boundary)}
+			if n.Boundary == "" {
+				boundaries[n.Boundary].Name = "Functions"
+			}
+			flow.Boundaries = append(flow.Boundaries, boundaries[n.Boundary])
+		}
+		boundaries[n.Boundary].Nodes = append(boundaries[n.Boundary].Nodes, n)
+	}
+
+	sort.Slice(flow.Boundaries, func(i, j int) bool { return flow.Boundaries[i].Id < flow.Boundaries[j].Id })
+	for _, b := range flow.Boundaries {
+		sort.Slice(b.Nodes, func(i, j int) bool { return b.Nodes[i].Id < b.Nodes[j].Id })
+	}
+	sort.SliceStable(flow.Edges, func(i, j int) bool {
+		a, b := flow.Edges[i], flow.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+
+	return flow
+}
+
+// statusColours are the fill colours of components by status.
+var statusColours = map[string]string{
+	StatusExposed:   "#f8cecc",
+	StatusAccepted:  "#ffe6cc",
+	StatusMitigated: "#d5e8d4",
+	StatusNone:      "#ffffff",
+}
+
+func dotQuote(text string) string {
+	return `"` + strings.Replace(strings.Replace(text, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+}
+
+// Dot renders the diagram in Graphviz DOT, with boundaries as clusters and
+// components filled by status: red when exposed, orange when exposures were
+// only transferred or accepted, and green when mitigated. Transfers are
+// dashed.
+func (f *DataFlow) Dot() string {
+	var dot strings.Builder
+	dot.WriteString("digraph threatspec {\n")
+	dot.WriteString("  rankdir=LR;\n")
+	dot.WriteString("  node [shape=box, style=\"rounded,filled\", fontname=\"Helvetica\"];\n")
+	dot.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+
+	for i, b := range f.Boundaries {
+		fmt.Fprintf(&dot, "\n  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&dot, "    label=%s;\n", dotQuote(b.Name))
+		dot.WriteString("    style=dashed;\n")
+		dot.WriteString("    color=\"#b85450\";\n")
+		for _, n := range b.Nodes {
+			attributes := fmt.Sprintf("label=%s, fillcolor=%s", dotQuote(n.Name), dotQuote(statusColours[n.Status]))
+			if len(n.Threats) > 0 {
+				attributes += ", tooltip=" + dotQuote("Exposed to "+strings.Join(n.Threats, ", "))
+			}
+			fmt.Fprintf(&dot, "    %s [%s];\n", dotQuote(n.Id), attributes)
+		}
+		dot.WriteString("  }\n")
+	}
+
+	if len(f.Edges) > 0 {
+		dot.WriteString("\n")
+	}
+	for _, e := range f.Edges {
+		if e.Kind == "transfer" {
+			fmt.Fprintf(&dot, "  %s -> %s [style=dashed, label=%s];\n", dotQuote(e.From), dotQuote(e.To), dotQuote(e.Label))
+		} else {
+			fmt.Fprintf(&dot, "  %s -> %s;\n", dotQuote(e.From), dotQuote(e.To))
+		}
+	}
+
+	dot.WriteString("}\n")
+	return dot.String()
+}
+
+// diagramId turns a node id into an identifier Mermaid and PlantUML accept.
+// Letters and digits are kept, underscores doubled and any other byte
+// written as _ and two hex digits, so distinct ids stay distinct.
+func diagramId(id string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(id); i++ {
+		switch c := id[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			escaped.WriteByte(c)
+		case c == '_':
+			escaped.WriteString("__")
+		default:
+			fmt.Fprintf(&escaped, "_%02x", c)
+		}
+	}
+	return escaped.String()
+}
+
+// threatIds numbers the threats nodes are exposed to, in order of first
+// appearance, for diagrams that draw threats as nodes of their own.
+func (f *DataFlow) threatIds() ([]string, map[string]string) {
+	names := make([]string, 0)
+	ids := make(map[string]string)
+	for _, b := range f.Boundaries {
+		for _, n := range b.Nodes {
+			for _, threat := range n.Threats {
+				if _, ok := ids[threat]; !ok {
+					ids[threat] = fmt.Sprintf("threat_%d", len(names))
+					names = append(names, threat)
+				}
+			}
+		}
+	}
+	return names, ids
+}
+
+func mermaidQuote(text string) string {
+	return `"` + strings.Replace(text, `"`, "#quot;", -1) + `"`
+}
+
+// Mermaid renders the diagram as a Mermaid flowchart, with boundaries as
+// subgraphs and components styled by status as in Dot. Threats are drawn as
+// hexagons with dotted edges to the components exposed to them.
+func (f *DataFlow) Mermaid() string {
+	var md strings.Builder
+	md.WriteString("flowchart LR\n")
+
+	statuses := make(map[string][]string)
+	for i, b := range f.Boundaries {
+		fmt.Fprintf(&md, "  subgraph boundary_%d [%s]\n", i, mermaidQuote(b.Name))
+		for _, n := range b.Nodes {
+			fmt.Fprintf(&md, "    %s[%s]\n", diagramId(n.Id), mermaidQuote(n.Name))
+			if n.Status != StatusNone {
+				statuses[n.Status] = append(statuses[n.Status], diagramId(n.Id))
+			}
+		}
+		md.WriteString("  end\n")
+	}
+
+	for _, e := range f.Edges {
+		if e.Kind == "transfer" {
+			fmt.Fprintf(&md, "  %s -.->|%s| %s\n", diagramId(e.From), mermaidQuote("transfers "+e.Label), diagramId(e.To))
+		} else {
+			fmt.Fprintf(&md, "  %s --> %s\n", diagramId(e.From), diagramId(e.To))
+		}
+	}
+
+	names, ids := f.threatIds()
+	for _, name := range names {
+		fmt.Fprintf(&md, "  %s{{%s}}\n", ids[name], mermaidQuote(name))
+	}
+	for _, b := range f.Boundaries {
+		for _, n := range b.Nodes {
+			for _, threat := range n.Threats {
+				fmt.Fprintf(&md, "  %s -. exposes .-> %s\n", ids[threat], diagramId(n.Id))
+			}
+		}
+	}
+
+	for _, status := range []string{StatusExposed, StatusAccepted, StatusMitigated} {
+		if len(statuses[status]) > 0 {
+			fmt.Fprintf(&md, "  classDef %s fill:%s\n", status, statusColours[status])
+			fmt.Fprintf(&md, "  class %s %s\n", strings.Join(statuses[status], ","), status)
+		}
+	}
+
+	return md.String()
+}
+
+// plantUmlText escapes text for PlantUML labels and notes, writing quotes
+// and backslashes as <U+XXXX> code points, which PlantUML decodes within
+// quoted names as well as in notes, and line breaks as \n.
+func plantUmlText(text string) string {
+	return strings.NewReplacer(`\`, "<U+005C>", `"`, "<U+0022>", "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(text)
+}
+
+func plantUmlQuote(text string) string {
+	return `"` + plantUmlText(text) + `"`
+}
+
+// PlantUml renders the diagram as a PlantUML component diagram, with
+// boundaries as packages and components coloured by status as in Dot. The
+// threats a component is exposed to are listed in a note beside it.
+func (f *DataFlow) PlantUml() string {
+	var uml strings.Builder
+	uml.WriteString("@startuml\n")
+	uml.WriteString("skinparam componentStyle rectangle\n")
+
+	for _, b := range f.Boundaries {
+		fmt.Fprintf(&uml, "\npackage %s {\n", plantUmlQuote(b.Name))
+		for _, n := range b.Nodes {
+			fmt.Fprintf(&uml, "  component %s as %s %s\n", plantUmlQuote(n.Name), diagramId(n.Id), statusColours[n.Status])
+		}
+		uml.WriteString("}\n")
+	}
+
+	if len(f.Edges) > 0 {
+		uml.WriteString("\n")
+	}
+	for _, e := range f.Edges {
+		if e.Kind == "transfer" {
+			fmt.Fprintf(&uml, "%s ..> %s : transfers %s\n", diagramId(e.From), diagramId(e.To), plantUmlText(e.Label))
+		} else {
+			fmt.Fprintf(&uml, "%s --> %s\n", diagramId(e.From), diagramId(e.To))
+		}
+	}
+
+	for _, b := range f.Boundaries {
+		for _, n := range b.Nodes {
+			if len(n.Threats) == 0 {
+				continue
+			}
+			fmt.Fprintf(&uml, "\nnote right of %s\n", diagramId(n.Id))
+			for _, threat := range n.Threats {
+				fmt.Fprintf(&uml, "  Exposed to %s\n", plantUmlText(threat))
+			}
+			uml.WriteString("end note\n")
+		}
+	}
+
+	uml.WriteString("@enduml\n")
+	return uml.String()
+}
+
+// Sizes used when laying out Svg, in pixels
+const (
+	svgMargin      = 20
+	svgPadding     = 20
+	svgTitle       = 24
+	svgNodeHeight  = 40
+	svgNodeSpacing = 30
+	svgColumnGap   = 120
+	svgCharWidth   = 7
+	svgLabelLength = 40
+)
+
+type svgBox struct {
+	x, y, width, height float64
+}
+
+func (b svgBox) centre() (float64, float64) {
+	return b.x + b.width/2, b.y + b.height/2
+}
+
+// edgePoint returns where the line from the centre of b towards (x, y)
+// leaves b.
+func (b svgBox) edgePoint(x, y float64) (float64, float64) {
+	cx, cy := b.centre()
+	dx, dy := x-cx, y-cy
+	if dx == 0 && dy == 0 {
+		return cx, cy
+	}
+	t := math.Inf(1)
+	if dx != 0 {
+		t = math.Min(t, b.width/2/math.Abs(dx))
+	}
+	if dy != 0 {
+		t = math.Min(t, b.height/2/math.Abs(dy))
+	}
+	return cx + dx*t, cy + dy*t
+}
+
+func svgText(text string) string {
+	return html.EscapeString(text)
+}
+
+// Svg renders the diagram as a standalone SVG image for embedding in HTML,
+// laid out without Graphviz: each boundary is a column of its components,
+// coloured by status as in Dot.
+func (f *DataFlow) Svg() string {
+	boxes := make(map[string]svgBox)
+	var shapes strings.Builder
+
+	x, height := float64(svgMargin), float64(0)
+	for _, b := range f.Boundaries {
+		width := float64(utf8.RuneCountInString(b.Name)*svgCharWidth + 2*svgPadding)
+		for _, n := range b.Nodes {
+			width = math.Max(width, float64(utf8.RuneCountInString(n.Name)*svgCharWidth+2*svgPadding+2*svgPadding))
+		}
+
+		y := float64(svgMargin + svgTitle + svgPadding)
+		for _, n := range b.Nodes {
+			box := svgBox{x + svgPadding, y, width - 2*svgPadding, svgNodeHeight}
+			boxes[n.Id] = box
+			fmt.Fprintf(&shapes, `<g class="node %s"><title>%s</title>`, n.Status, svgText(strings.Join(append([]string{n.Name}, n.Threats...), "\n")))
+			fmt.Fprintf(&shapes, `<rect x="%.0f" y="%.0f" width="%.0f" height="%.0f" rx="6" fill="%s" stroke="#666"/>`,
+				box.x, box.y, box.width, box.height, statusColours[n.Status])
+			cx, cy := box.centre()
+			fmt.Fprintf(&shapes, `<text x="%.0f" y="%.0f" text-anchor="middle" dominant-baseline="middle">%s</text></g>`, cx, cy, svgText(n.Name))
+			y += svgNodeHeight + svgNodeSpacing
+		}
+
+		boundaryHeight := y - svgNodeSpacing + svgPadding - svgMargin
+		fmt.Fprintf(&shapes, `<g class="boundary"><rect x="%.0f" y="%d" width="%.0f" height="%.0f" fill="none" stroke="#b85450" stroke-dasharray="6,4"/>`,
+			x, svgMargin, width, boundaryHeight)
+		fmt.Fprintf(&shapes, `<text x="%.0f" y="%d" font-weight="bold">%s</text></g>`, x+svgPadding/2, svgMargin+svgTitle-4, svgText(b.Name))
+
+		height = math.Max(height, boundaryHeight)
+		x += width + svgColumnGap
+	}
+
+	var lines strings.Builder
+	for _, e := range f.Edges {
+		from, ok := boxes[e.From]
+		to, found := boxes[e.To]
+		if !ok || !found {
+			continue
+		}
+		fx, fy := from.centre()
+		tx, ty := to.centre()
+		x1, y1 := from.edgePoint(tx, ty)
+		x2, y2 := to.edgePoint(fx, fy)
+
+		style := ""
+		if e.Kind == "transfer" {
+			style = ` stroke-dasharray="5,3"`
+		}
+		fmt.Fprintf(&lines, `<line x1="%.0f" y1="%.0f" x2="%.0f" y2="%.0f" stroke="#333"%s marker-end="url(#arrow)"/>`, x1, y1, x2, y2, style)
+		if e.Label != "" {
+			label := e.Label
+			if runes := []rune(label); len(runes) > svgLabelLength {
+				label = string(runes[:svgLabelLength-3]) + "..."
+			}
+			fmt.Fprintf(&lines, `<text x="%.0f" y="%.0f" text-anchor="middle" font-size="10"><title>%s</title>%s</text>`,
+				(x1+x2)/2, (y1+y2)/2-4, svgText(e.Label), svgText(label))
+		}
+	}
+
+	width := math.Max(x-svgColumnGap+svgMargin, 2*svgMargin)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" font-family="Helvetica, Arial, sans-serif" font-size="12">`+
+		`<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" orient="auto-start-reverse">`+
+		`<path d="M 0 0 L 10 5 L 0 10 z" fill="#333"/></marker></defs>%s%s</svg>`,
+		width, height+2*svgMargin, shapes.String(), lines.String())
+}
@@ -0,0 +1,122 @@
+package threatspec
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestDiagramId(t *testing.T) {
+	ids := []string{"a_b:c", "a:b_c", "a_b_c", "a__b:c", "web app:db", "web_20app:db", "webapp:db"}
+	seen := make(map[string]string)
+	for _, id := range ids {
+		escaped := diagramId(id)
+		if strings.Trim(escaped, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_") != "" {
+			t.Errorf("diagramId(%q) = %q, which is not a plain identifier", id, escaped)
+		}
+		if other, ok := seen[escaped]; ok {
+			t.Errorf("diagramId(%q) and diagramId(%q) are both %q", id, other, escaped)
+		}
+		seen[escaped] = id
+	}
+}
+
+func TestPlantUmlEscaping(t *testing.T) {
+	ts := New("wiki")
+	ts.AddBoundary("@webapp", `Web "App"`)
+	ts.AddComponent("@app", "App")
+	ts.AddComponent("@browser", "Browser")
+	ts.AddThreat("@xss", "XSS\nend note")
+	ts.AddThreat("@leak", `C:\ leak`)
+	source := &Source{Function: "main.handler"}
+	ts.AddExposure("@raw", &Exposure{Exposure: "raw", Boundary: "@webapp", Component: "@app", Threat: "@xss", Source: source})
+	ts.AddTransfer("@tls", &Transfer{Transfer: "tls", Boundary: "@webapp", Component: "@browser", Threat: "@leak", Source: source})
+
+	uml := ts.DataFlow().PlantUml()
+	for _, want := range []string{
+		`package "Web <U+0022>App<U+0022>" {`,
+		"  Exposed to XSS\\nend note\n",
+		"webapp_3aapp ..> webapp_3abrowser : transfers C:<U+005C> leak\n",
+	} {
+		if !strings.Contains(uml, want) {
+			t.Errorf("PlantUml() does not contain %q:\n%s", want, uml)
+		}
+	}
+	if strings.Count(uml, "\nend note\n") != 1 {
+		t.Errorf("threat name ended the note early:\n%s", uml)
+	}
+}
+
+func TestPlantUmlRender(t *testing.T) {
+	ts := New("wiki")
+	ts.AddBoundary("@webapp", "WebApp")
+	ts.AddComponent("@app", `The "App"`)
+	ts.AddComponent("@browser", "Browser")
+	ts.AddThreat("@xss", "XSS")
+	ts.AddThreat("@leak", "Leak")
+	source := &Source{Function: "main.handler"}
+	ts.AddExposure("@raw", &Exposure{Exposure: "raw", Boundary: "@webapp", Component: "@app", Threat: "@xss", Source: source})
+	ts.AddTransfer("@tls", &Transfer{Transfer: "tls", Boundary: "@webapp", Component: "@browser", Threat: "@leak", Source: source})
+
+	want := `@startuml
+skinparam componentStyle rectangle
+
+package "WebApp" {
+  component "The <U+0022>App<U+0022>" as webapp_3aapp #f8cecc
+  component "Browser" as webapp_3abrowser #ffffff
+}
+
+webapp_3aapp ..> webapp_3abrowser : transfers Leak
+
+note right of webapp_3aapp
+  Exposed to XSS
+end note
+@enduml
+`
+	if uml := ts.DataFlow().PlantUml(); uml != want {
+		t.Errorf("PlantUml() =\n%s\nwant\n%s", uml, want)
+	}
+}
+
+func TestSvgLabelTruncation(t *testing.T) {
+	ts := New("wiki")
+	ts.AddComponent("@app", "App")
+	ts.AddComponent("@browser", "Browser")
+	ts.AddThreat("@leak", strings.Repeat("é", 60))
+	source := &Source{Function: "main.handler"}
+	ts.AddExposure("@raw", &Exposure{Exposure: "raw", Boundary: ts.AddBoundary("", "WebApp"), Component: "@app", Threat: "@leak", Source: source})
+	ts.AddTransfer("@tls", &Transfer{Transfer: "tls", Boundary: "@webapp", Component: "@browser", Threat: "@leak", Source: source})
+
+	svg := ts.DataFlow().Svg()
+	if !utf8.ValidString(svg) {
+		t.Error("Svg() is not valid UTF-8")
+	}
+	if want := strings.Repeat("é", svgLabelLength-3) + "...</text>"; !strings.Contains(svg, want) {
+		t.Errorf("Svg() does not truncate the label to %d characters:\n%s", svgLabelLength, svg)
+	}
+}
+
+func TestTransferOnlyFunction(t *testing.T) {
+	ts := New("wiki")
+	ts.AddComponent("@app", "App")
+	ts.AddComponent("@browser", "Browser")
+	ts.AddThreat("@xss", "XSS")
+	ts.AddThreat("@leak", "Leak")
+	ts.AddExposure("@raw", &Exposure{Exposure: "raw", Boundary: "@webapp", Component: "@app", Threat: "@xss", Source: &Source{Function: "main.handler"}})
+	ts.AddTransfer("@tls", &Transfer{Transfer: "tls", Boundary: "@webapp", Component: "@browser", Threat: "@leak", Source: &Source{Function: "main.serve"}})
+	ts.CallFlow = []*Call{{Source: "main.handler", Destination: "main.serve"}}
+
+	flow := ts.DataFlow()
+	if len(flow.Boundaries) != 2 || flow.Boundaries[0].Name != "Functions" || len(flow.Boundaries[0].Nodes) != 1 || flow.Boundaries[0].Nodes[0].Id != "main.serve" {
+		t.Fatalf("DataFlow boundaries = %+v, want main.serve in Functions", flow.Boundaries)
+	}
+
+	edges := make([]string, 0)
+	for _, e := range flow.Edges {
+		edges = append(edges, e.From+" -> "+e.To+" "+e.Kind)
+	}
+	want := "main.serve -> webapp:browser transfer, webapp:app -> main.serve call"
+	if strings.Join(edges, ", ") != want {
+		t.Errorf("DataFlow edges = %v, want %s", edges, want)
+	}
+}
@@ -0,0 +1,128 @@
+package threatspec
+
+import (
+	"fmt"
+	"go/scanner"
+	"golang.org/x/tools/go/packages"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+var positionPattern = regexp.MustCompile(`^(.+?):([0-9]+)(?::([0-9]+))?$`)
+
+// Diagnostic describes a problem found while parsing an input. Kind is the
+// annotation involved, such as mitigates or alias, when there is one.
+type Diagnostic struct {
+	Severity   string
+	File       string
+	Line       int
+	Column     int
+	Kind       string
+	Message    string
+	Suggestion string
+}
+
+// Error renders the diagnostic in the file:line:col: message form understood
+// by editors and CI systems. A suggestion follows on an indented line.
+func (d *Diagnostic) Error() string {
+	position := d.File
+	if d.Line > 0 {
+		position = fmt.Sprintf("%s:%d", position, d.Line)
+		if d.Column > 0 {
+			position = fmt.Sprintf("%s:%d", position, d.Column)
+		}
+	}
+
+	message := d.Message
+	if d.Severity == SeverityWarning {
+		message = "warning: " + message
+	}
+	if d.Suggestion != "" {
+		message = fmt.Sprintf("%s\n\tdid you mean: %s", message, d.Suggestion)
+	}
+	if position == "" {
+		return message
+	}
+	return fmt.Sprintf("%s: %s", position, message)
+}
+
+// Diagnostics collects every problem found across all inputs.
+type Diagnostics []*Diagnostic
+
+func (ds Diagnostics) Error() string {
+	lines := make([]string, 0, len(ds))
+	for _, d := range ds {
+		lines = append(lines, d.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HasErrors reports whether any of the diagnostics is an error rather than a
+// warning.
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func annotationDiagnostic(file string, line commentLine, kind string) *Diagnostic {
+	_, message, suggestion := diagnose(line.text)
+	if message == "" {
+		message = fmt.Sprintf("malformed @%s annotation: %s", kind, strings.TrimSpace(line.text))
+	}
+
+	return &Diagnostic{
+		Severity:   SeverityError,
+		File:       file,
+		Line:       line.line,
+		Column:     line.column + strings.Index(line.text, "@"),
+		Kind:       kind,
+		Message:    message,
+		Suggestion: suggestion,
+	}
+}
+
+// ToDiagnostics converts err into diagnostics, keeping the positions of Go
+// syntax and package loading errors. Errors without a position are
+// attributed to file.
+func ToDiagnostics(file string, err error) Diagnostics {
+	switch x := err.(type) {
+	case nil:
+		return nil
+	case Diagnostics:
+		return x
+	case *Diagnostic:
+		return Diagnostics{x}
+	case scanner.ErrorList:
+		diagnostics := make(Diagnostics, 0, len(x))
+		for _, e := range x {
+			diagnostics = append(diagnostics, &Diagnostic{
+				Severity: SeverityError,
+				File:     displayPath(e.Pos.Filename),
+				Line:     e.Pos.Line,
+				Column:   e.Pos.Column,
+				Message:  e.Msg,
+			})
+		}
+		return diagnostics
+	case packages.Error:
+		diagnostic := &Diagnostic{Severity: SeverityError, File: file, Message: x.Msg}
+		if m := positionPattern.FindStringSubmatch(x.Pos); m != nil {
+			diagnostic.File = displayPath(m[1])
+			diagnostic.Line, _ = strconv.Atoi(m[2])
+			diagnostic.Column, _ = strconv.Atoi(m[3])
+		}
+		return Diagnostics{diagnostic}
+	}
+
+	return Diagnostics{&Diagnostic{Severity: SeverityError, File: file, Message: err.Error()}}
+}
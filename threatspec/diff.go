@@ -0,0 +1,280 @@
+package threatspec
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Change is one difference between two specs. Kind is boundary, component,
+// threat, mitigation, exposure, transfer or acceptance, and Description a
+// readable summary. Annotations also record their project and Source, and
+// moved annotations the Source they moved From.
+type Change struct {
+	Kind        string  `json:"kind"`
+	Project     string  `json:"project,omitempty"`
+	Id          Id      `json:"id"`
+	Description string  `json:"description"`
+	Source      *Source `json:"source,omitempty"`
+	From        *Source `json:"from,omitempty"`
+}
+
+// SpecDiff holds the changes from one spec to another. Changed lists
+// boundaries, components and threats whose definition differs, and Moved
+// annotations that are unchanged apart from their function or file.
+type SpecDiff struct {
+	Added   []*Change `json:"added"`
+	Removed []*Change `json:"removed"`
+	Changed []*Change `json:"changed"`
+	Moved   []*Change `json:"moved"`
+}
+
+func definitionName(definition interface{}) string {
+	switch x := definition.(type) {
+	case *Boundary:
+		if x != nil {
+			return x.Name
+		}
+	case *Component:
+		if x != nil {
+			return x.Name
+		}
+	case *Threat:
+		if x != nil {
+			return x.Name
+		}
+	}
+	return ""
+}
+
+// diffDefinitions compares two maps of boundaries, components or threats.
+func (d *SpecDiff) diffDefinitions(kind string, a, b interface{}) {
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+
+	ids := make(map[Id]bool)
+	for _, key := range av.MapKeys() {
+		ids[Id(key.String())] = true
+	}
+	for _, key := range bv.MapKeys() {
+		ids[Id(key.String())] = true
+	}
+
+	for _, id := range sortedIds(ids) {
+		key := reflect.ValueOf(id)
+		left, right := av.MapIndex(key), bv.MapIndex(key)
+		switch {
+		case !left.IsValid() || left.IsNil():
+			d.Added = append(d.Added, &Change{Kind: kind, Id: id, Description: definitionName(right.Interface())})
+		case !right.IsValid() || right.IsNil():
+			d.Removed = append(d.Removed, &Change{Kind: kind, Id: id, Description: definitionName(left.Interface())})
+		case !reflect.DeepEqual(left.Interface(), right.Interface()):
+			description := definitionName(right.Interface())
+			if from := definitionName(left.Interface()); from != description {
+				description = fmt.Sprintf("%s (was %s)", description, from)
+			}
+			d.Changed = append(d.Changed, &Change{Kind: kind, Id: id, Description: description})
+		}
+	}
+}
+
+// describe summarises an annotation using the names in ts.
+func (ts *ThreatSpec) describe(annotation *Annotation) string {
+	pair := ts.BoundaryName(annotation.Boundary) + ":" + ts.ComponentName(annotation.Component)
+	threat := ts.ThreatName(annotation.Threat)
+
+	switch annotation.Kind {
+	case "mitigation":
+		return fmt.Sprintf("%s against %s with %s", pair, threat, annotation.Text)
+	case "exposure":
+		return fmt.Sprintf("%s to %s with %s", pair, threat, annotation.Text)
+	}
+	return fmt.Sprintf("%s to %s with %s", threat, pair, annotation.Text)
+}
+
+// annotationKey identifies an annotation apart from where it was found.
+func annotationKey(annotation *Annotation) string {
+	return strings.Join([]string{annotation.Project, annotation.Kind, string(annotation.Id),
+		string(annotation.Boundary), string(annotation.Component), string(annotation.Threat),
+		annotation.Text, strings.Join(annotation.References, ",")}, "|")
+}
+
+// sameLocation reports whether two sources name the same function in the
+// same file. Line numbers are ignored, as unrelated edits move them.
+func sameLocation(a, b *Source) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Function == b.Function && a.File == b.File
+}
+
+// Diff compares spec a with spec b, such as the specs generated from a main
+// branch and from a pull request.
+func Diff(a, b *ThreatSpec) *SpecDiff {
+	d := &SpecDiff{
+		Added:   make([]*Change, 0),
+		Removed: make([]*Change, 0),
+		Changed: make([]*Change, 0),
+		Moved:   make([]*Change, 0),
+	}
+
+	d.diffDefinitions("boundary", a.Boundaries, b.Boundaries)
+	d.diffDefinitions("component", a.Components, b.Components)
+	d.diffDefinitions("threat", a.Threats, b.Threats)
+
+	previous := a.Annotations()
+	before := make(map[string][]*Annotation)
+	for _, annotation := range previous {
+		key := annotationKey(annotation)
+		before[key] = append(before[key], annotation)
+	}
+
+	change := func(ts *ThreatSpec, annotation *Annotation) *Change {
+		return &Change{
+			Kind:        annotation.Kind,
+			Project:     annotation.Project,
+			Id:          annotation.Id,
+			Description: ts.describe(annotation),
+			Source:      annotation.Source,
+		}
+	}
+
+	// Pair up annotations found in the same place first, so that only the
+	// remainder count as moved
+	unmatched := make([]*Annotation, 0)
+	for _, annotation := range b.Annotations() {
+		key := annotationKey(annotation)
+		matched := false
+		for i, candidate := range before[key] {
+			if sameLocation(candidate.Source, annotation.Source) {
+				before[key] = append(before[key][:i], before[key][i+1:]...)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			unmatched = append(unmatched, annotation)
+		}
+	}
+
+	for _, annotation := range unmatched {
+		key := annotationKey(annotation)
+		if len(before[key]) > 0 {
+			moved := change(b, annotation)
+			moved.From = before[key][0].Source
+			before[key] = before[key][1:]
+			d.Moved = append(d.Moved, moved)
+		} else {
+			d.Added = append(d.Added, change(b, annotation))
+		}
+	}
+
+	for _, annotation := range previous {
+		key := annotationKey(annotation)
+		for i, remaining := range before[key] {
+			if remaining == annotation {
+				before[key] = append(before[key][:i], before[key][i+1:]...)
+				d.Removed = append(d.Removed, change(a, annotation))
+				break
+			}
+		}
+	}
+
+	return d
+}
+
+// Empty reports whether the specs had no differences.
+func (d *SpecDiff) Empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Changed)+len(d.Moved) == 0
+}
+
+func (d *SpecDiff) ToJson() string {
+	dump, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(dump)
+}
+
+func describeSource(source *Source) string {
+	if source == nil {
+		return ""
+	}
+	if source.Function == "" {
+		return fmt.Sprintf("%s:%d", source.File, source.Line)
+	}
+	return fmt.Sprintf("%s (%s:%d)", source.Function, source.File, source.Line)
+}
+
+func (c *Change) String() string {
+	text := fmt.Sprintf("%s %s", c.Kind, c.Id)
+	if c.Project != "" {
+		text += fmt.Sprintf(" [%s]", c.Project)
+	}
+	if c.Description != "" {
+		text += ": " + c.Description
+	}
+	if c.From != nil {
+		text += fmt.Sprintf(", moved from %s to %s", describeSource(c.From), describeSource(c.Source))
+	} else if c.Source != nil {
+		text += " in " + describeSource(c.Source)
+	}
+	return text
+}
+
+// Text renders the diff with one change per line, prefixed by +, -, ~ or >
+// for added, removed, changed and moved.
+func (d *SpecDiff) Text() string {
+	lines := make([]string, 0)
+	for _, section := range []struct {
+		prefix  string
+		changes []*Change
+	}{{"+", d.Added}, {"-", d.Removed}, {"~", d.Changed}, {">", d.Moved}} {
+		for _, c := range section.changes {
+			lines = append(lines, section.prefix+" "+c.String())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Markdown renders the diff for a pull request comment, with a section for
+// each type of change.
+func (d *SpecDiff) Markdown() string {
+	var md strings.Builder
+	md.WriteString("## Threat model changes\n\n")
+	if d.Empty() {
+		md.WriteString("No changes to the threat model.\n")
+		return md.String()
+	}
+
+	counts := make([]string, 0)
+	for _, section := range []struct {
+		title   string
+		changes []*Change
+	}{{"Added", d.Added}, {"Removed", d.Removed}, {"Changed", d.Changed}, {"Moved", d.Moved}} {
+		if len(section.changes) == 0 {
+			continue
+		}
+		counts = append(counts, fmt.Sprintf("%d %s", len(section.changes), strings.ToLower(section.title)))
+
+		fmt.Fprintf(&md, "### %s\n\n", section.title)
+		changes := append([]*Change{}, section.changes...)
+		sort.SliceStable(changes, func(i, j int) bool { return changes[i].Kind < changes[j].Kind })
+		for _, c := range changes {
+			fmt.Fprintf(&md, "- **%s** `%s`", c.Kind, c.Id)
+			if c.Description != "" {
+				fmt.Fprintf(&md, " %s", c.Description)
+			}
+			if c.From != nil {
+				fmt.Fprintf(&md, ", moved from `%s` to `%s`", describeSource(c.From), describeSource(c.Source))
+			} else if c.Source != nil {
+				fmt.Fprintf(&md, " in `%s`", describeSource(c.Source))
+			}
+			md.WriteString("\n")
+		}
+		md.WriteString("\n")
+	}
+
+	return fmt.Sprintf("%s_%s_\n", md.String(), strings.Join(counts, ", "))
+}
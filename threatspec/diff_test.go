@@ -0,0 +1,120 @@
+package threatspec
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func diffFixture() *ThreatSpec {
+	ts := New("wiki")
+	ts.AddBoundary("", "WebApp")
+	ts.AddComponent("", "App")
+	ts.AddComponent("", "FileSystem")
+	ts.AddThreat("", "XSS")
+	ts.AddExposure("@raw_output", &Exposure{Exposure: "raw output", Boundary: "@webapp", Component: "@app", Threat: "@xss",
+		Source: &Source{Function: "main.editHandler", File: "simple.go", Line: 54}})
+	ts.AddMitigation("@escaping", &Mitigation{Mitigation: "escaping", Boundary: "@webapp", Component: "@app", Threat: "@xss",
+		Source: &Source{Function: "main.renderTemplate", File: "simple.go", Line: 76}})
+	return ts
+}
+
+func changeSummaries(changes []*Change) []string {
+	summaries := make([]string, 0, len(changes))
+	for _, c := range changes {
+		summaries = append(summaries, c.Kind+" "+string(c.Id))
+	}
+	return summaries
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name                           string
+		edit                           func(ts *ThreatSpec)
+		added, removed, changed, moved []string
+	}{
+		{
+			"line numbers only",
+			func(ts *ThreatSpec) {
+				ts.Projects["wiki"].Exposures["@raw_output"][0].Source.Line = 60
+			},
+			nil, nil, nil, nil,
+		},
+		{
+			"moved to another function",
+			func(ts *ThreatSpec) {
+				ts.Projects["wiki"].Mitigations["@escaping"][0].Source.Function = "main.viewHandler"
+			},
+			nil, nil, nil, []string{"mitigation @escaping"},
+		},
+		{
+			"changed threat",
+			func(ts *ThreatSpec) {
+				ts.Threats["@xss"].Severity = "high"
+			},
+			nil, nil, []string{"threat @xss"}, nil,
+		},
+		{
+			"added and removed",
+			func(ts *ThreatSpec) {
+				delete(ts.Projects["wiki"].Mitigations, "@escaping")
+				delete(ts.Components, "@filesystem")
+				ts.AddThreat("", "Path traversal")
+				ts.AddExposure("@unchecked_path", &Exposure{Exposure: "unchecked path", Boundary: "@webapp", Component: "@app", Threat: "@path_traversal",
+					Source: &Source{Function: "main.loadPage", File: "simple.go", Line: 35}})
+			},
+			[]string{"threat @path_traversal", "exposure @unchecked_path"},
+			[]string{"component @filesystem", "mitigation @escaping"},
+			nil, nil,
+		},
+		{
+			"changed text is not a move",
+			func(ts *ThreatSpec) {
+				ts.Projects["wiki"].Exposures["@raw_output"][0].References = []string{"SEC-42"}
+			},
+			[]string{"exposure @raw_output"}, []string{"exposure @raw_output"}, nil, nil,
+		},
+	}
+
+	for _, test := range tests {
+		a, b := diffFixture(), diffFixture()
+		test.edit(b)
+		d := Diff(a, b)
+
+		for _, section := range []struct {
+			name      string
+			got, want []string
+		}{
+			{"added", changeSummaries(d.Added), test.added},
+			{"removed", changeSummaries(d.Removed), test.removed},
+			{"changed", changeSummaries(d.Changed), test.changed},
+			{"moved", changeSummaries(d.Moved), test.moved},
+		} {
+			if len(section.got) == 0 && len(section.want) == 0 {
+				continue
+			}
+			if !reflect.DeepEqual(section.got, section.want) {
+				t.Errorf("%s: %s = %q, want %q", test.name, section.name, section.got, section.want)
+			}
+		}
+		if d.Empty() != (len(test.added)+len(test.removed)+len(test.changed)+len(test.moved) == 0) {
+			t.Errorf("%s: Empty() = %v", test.name, d.Empty())
+		}
+	}
+}
+
+// Annotations may refer to ids the spec does not define.
+func TestDiffUndefinedIds(t *testing.T) {
+	a, b := diffFixture(), diffFixture()
+	b.AddExposure("@leak", &Exposure{Exposure: "leak", Boundary: "@undefined", Component: "@app", Threat: "@missing"})
+
+	d := Diff(a, b)
+	if len(d.Added) != 1 || !strings.HasPrefix(d.Added[0].Description, "@undefined:App to @missing") {
+		t.Errorf("Added = %v, want the undefined ids described by id", d.Added)
+	}
+
+	d = Diff(b, a)
+	if len(d.Removed) != 1 || !strings.Contains(d.Text(), "- exposure @leak [wiki]: @undefined:App to @missing with leak") {
+		t.Errorf("Text() = %q", d.Text())
+	}
+}
@@ -0,0 +1,188 @@
+package threatspec
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var generatedPattern = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// Options controls which files are picked up when directories and package
+// patterns are expanded, and how Go packages are loaded. Files given
+// explicitly are always parsed unless build constraints exclude them.
+// CallFlow type checks the loaded packages and fills in ThreatSpec.CallFlow.
+// Projects assigns annotations to projects by the path of the file they are
+// found in; the first matching rule wins. MergePolicy resolves conflicts
+// with JSON specs loaded alongside the sources.
+type Options struct {
+	Include     []string
+	Exclude     []string
+	Tags        []string
+	GOOS        string
+	GOARCH      string
+	CallFlow    bool
+	Projects    []ProjectRule
+	MergePolicy MergePolicy
+}
+
+// displayPath reports filename relative to the working directory when it lies
+// beneath it, so sources read the same as the paths given on the command line.
+func displayPath(filename string) string {
+	wd, err := os.Getwd()
+	if err != nil || !filepath.IsAbs(filename) {
+		return filename
+	}
+	if rel, err := filepath.Rel(wd, filename); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return filename
+}
+
+func matchesAny(filename string, patterns []string) bool {
+	slashed := filepath.ToSlash(filename)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, slashed); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(slashed)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func skipDir(dir string) bool {
+	name := filepath.Base(dir)
+	switch {
+	case name == "vendor", name == "testdata":
+		return true
+	case strings.HasPrefix(name, "."), strings.HasPrefix(name, "_"):
+		return true
+	}
+	return false
+}
+
+// IsGenerated reports whether a Go source file carries the standard
+// "Code generated ... DO NOT EDIT." header before its package clause.
+func IsGenerated(filename string) (bool, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if generatedPattern.MatchString(line) {
+			return true, nil
+		}
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+	}
+	return false, scanner.Err()
+}
+
+func (ts *ThreatSpec) wantFile(filename string) (bool, error) {
+	switch path.Ext(filename) {
+	case ".go":
+		if generated, err := IsGenerated(filename); err != nil || generated {
+			return false, err
+		}
+	case ".threatspec":
+	default:
+		return false, nil
+	}
+
+	if len(ts.Options.Include) > 0 && !matchesAny(filename, ts.Options.Include) {
+		return false, nil
+	}
+	return !matchesAny(filename, ts.Options.Exclude), nil
+}
+
+func (ts *ThreatSpec) expandDir(dir string, recursive bool) ([]string, error) {
+	filenames := make([]string, 0)
+
+	err := filepath.Walk(dir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if filename == dir {
+				return nil
+			}
+			if !recursive || skipDir(filename) || matchesAny(filename, ts.Options.Exclude) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		want, err := ts.wantFile(filename)
+		if err != nil {
+			return err
+		}
+		if want {
+			filenames = append(filenames, filename)
+		}
+		return nil
+	})
+
+	return filenames, err
+}
+
+// ExpandPaths turns a list of files, directories and Go package patterns
+// such as ./... into the list of files to parse. Directories contribute
+// their .go and .threatspec files, recursively when suffixed with /...
+// Each file is listed once, however many of the paths match it.
+func (ts *ThreatSpec) ExpandPaths(paths []string) ([]string, error) {
+	filenames := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(filename string) {
+		filename = filepath.Clean(filename)
+		key := filename
+		if abs, err := filepath.Abs(filename); err == nil {
+			key = abs
+		}
+		if !seen[key] {
+			seen[key] = true
+			filenames = append(filenames, filename)
+		}
+	}
+
+	for _, p := range paths {
+		recursive := false
+		if p == "..." || strings.HasSuffix(p, "/...") {
+			recursive = true
+			p = strings.TrimSuffix(strings.TrimSuffix(p, "..."), "/")
+			if p == "" {
+				p = "."
+			}
+		}
+
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			add(p)
+			continue
+		}
+
+		found, err := ts.expandDir(p, recursive)
+		if err != nil {
+			return nil, err
+		}
+		for _, filename := range found {
+			add(filename)
+		}
+	}
+
+	return filenames, nil
+}
@@ -0,0 +1,78 @@
+package threatspec
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// chdir changes to dir for the rest of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestExpandPaths(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.threatspec":          "",
+		"main.go":               "package main\n",
+		"sub/b.threatspec":      "",
+		"sub/gen.go":            "// Code generated by hand. DO NOT EDIT.\n\npackage sub\n",
+		"vendor/c.threatspec":   "",
+		"testdata/d.threatspec": "",
+		"notes.txt":             "",
+	})
+	chdir(t, dir)
+
+	tests := []struct {
+		paths []string
+		want  []string
+	}{
+		{[]string{"."}, []string{"a.threatspec", "main.go"}},
+		{[]string{"./..."}, []string{"a.threatspec", "main.go", "sub/b.threatspec"}},
+		{[]string{"...", "./..."}, []string{"a.threatspec", "main.go", "sub/b.threatspec"}},
+		{[]string{".", "./..."}, []string{"a.threatspec", "main.go", "sub/b.threatspec"}},
+		{[]string{"./a.threatspec", "."}, []string{"a.threatspec", "main.go"}},
+		{[]string{"sub/b.threatspec", dir + "/sub/b.threatspec"}, []string{"sub/b.threatspec"}},
+		{[]string{"notes.txt"}, []string{"notes.txt"}},
+	}
+
+	ts := New("test")
+	for _, test := range tests {
+		got, err := ts.ExpandPaths(test.paths)
+		if err != nil {
+			t.Errorf("ExpandPaths(%q): %v", test.paths, err)
+			continue
+		}
+		want := make([]string, 0, len(test.want))
+		for _, filename := range test.want {
+			want = append(want, filepath.FromSlash(filename))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ExpandPaths(%q) = %q, want %q", test.paths, got, want)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package threatspec
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/packages"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// loadMode returns what go/packages needs to load. Type checking a package
+// needs the types of everything it imports, so call flow also loads the
+// dependencies.
+func (ts *ThreatSpec) loadMode() packages.LoadMode {
+	mode := packages.NeedName | packages.NeedFiles | packages.NeedSyntax
+	if ts.Options.CallFlow {
+		mode |= packages.NeedImports | packages.NeedDeps | packages.NeedTypes | packages.NeedTypesInfo
+	}
+	return mode
+}
+
+// moduleRoot returns the directory of the go.mod governing dir, or "" when
+// dir is not part of a module.
+func moduleRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func (ts *ThreatSpec) buildFlags() []string {
+	if len(ts.Options.Tags) == 0 {
+		return nil
+	}
+	return []string{"-tags", strings.Join(ts.Options.Tags, ",")}
+}
+
+func (ts *ThreatSpec) buildEnv() []string {
+	env := os.Environ()
+	if ts.Options.GOOS != "" {
+		env = append(env, "GOOS="+ts.Options.GOOS)
+	}
+	if ts.Options.GOARCH != "" {
+		env = append(env, "GOARCH="+ts.Options.GOARCH)
+	}
+	return env
+}
+
+func (ts *ThreatSpec) buildContext() build.Context {
+	ctx := build.Default
+	ctx.BuildTags = ts.Options.Tags
+	if ts.Options.GOOS != "" {
+		ctx.GOOS = ts.Options.GOOS
+	}
+	if ts.Options.GOARCH != "" {
+		ctx.GOARCH = ts.Options.GOARCH
+	}
+	return ctx
+}
+
+// LoadPackages loads the packages containing the given Go files, honouring
+// build tags, GOOS/GOARCH and module boundaries. Packages in a module carry
+// the syntax of all of their files, while directories outside of any module
+// are loaded with go/build and only carry the requested files. Packages are
+// type checked when Options.CallFlow is set. Syntax errors are returned as
+// Diagnostics alongside the packages, which still hold the partial syntax.
+func (ts *ThreatSpec) LoadPackages(filenames []string) ([]*packages.Package, error) {
+	fset := token.NewFileSet()
+	wanted := make(map[string]bool)
+	modules := make(map[string]map[string]bool)
+	tests := make(map[string]bool)
+
+	for _, filename := range filenames {
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return nil, err
+		}
+		wanted[abs] = true
+
+		dir := filepath.Dir(abs)
+		root := moduleRoot(dir)
+		if modules[root] == nil {
+			modules[root] = make(map[string]bool)
+		}
+		modules[root][dir] = true
+		if strings.HasSuffix(abs, "_test.go") {
+			tests[root] = true
+		}
+	}
+
+	roots := make([]string, 0, len(modules))
+	for root := range modules {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+
+	result := make([]*packages.Package, 0)
+	diagnostics := make(Diagnostics, 0)
+
+	for _, root := range roots {
+		dirs := make([]string, 0, len(modules[root]))
+		for dir := range modules[root] {
+			dirs = append(dirs, dir)
+		}
+		sort.Strings(dirs)
+
+		var pkgs []*packages.Package
+		var err error
+		if root == "" {
+			pkgs, err = ts.loadBuildPackages(fset, dirs, wanted)
+		} else {
+			pkgs, err = ts.loadModulePackages(fset, root, dirs, wanted, tests[root])
+		}
+		if loadDiagnostics, ok := err.(Diagnostics); ok {
+			diagnostics = append(diagnostics, loadDiagnostics...)
+		} else if err != nil {
+			return nil, err
+		}
+		result = append(result, pkgs...)
+	}
+
+	if len(diagnostics) > 0 {
+		return result, diagnostics
+	}
+	return result, nil
+}
+
+func (ts *ThreatSpec) loadModulePackages(fset *token.FileSet, root string, dirs []string, wanted map[string]bool, tests bool) ([]*packages.Package, error) {
+	cfg := &packages.Config{
+		Mode:       ts.loadMode(),
+		Dir:        root,
+		Env:        ts.buildEnv(),
+		BuildFlags: ts.buildFlags(),
+		Fset:       fset,
+		Tests:      tests,
+	}
+
+	patterns := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, "./"+filepath.ToSlash(rel))
+	}
+
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	diagnostics := make(Diagnostics, 0)
+	for _, pkg := range pkgs {
+		for _, pkgErr := range pkg.Errors {
+			if pkgErr.Kind == packages.ParseError {
+				diagnostics = append(diagnostics, ToDiagnostics(root, pkgErr)...)
+			}
+		}
+	}
+
+	if ts.Options.CallFlow {
+		for _, pkg := range pkgs {
+			narrowPackage(pkg, wanted)
+		}
+	}
+
+	if len(diagnostics) > 0 {
+		return pkgs, diagnostics
+	}
+	return pkgs, nil
+}
+
+// narrowPackage type checks only the requested files of a package that does
+// not type check as a whole, such as a directory of programs that each
+// declare main, so call flow can still be built from them. Packages with
+// errors other than type errors are left alone.
+func narrowPackage(pkg *packages.Package, wanted map[string]bool) {
+	if !pkg.IllTyped || pkg.Types == nil {
+		return
+	}
+	for _, pkgErr := range pkg.Errors {
+		if pkgErr.Kind != packages.TypeError {
+			return
+		}
+	}
+
+	files := make([]string, 0)
+	syntax := make([]*ast.File, 0)
+	for _, f := range pkg.Syntax {
+		filename := pkg.Fset.Position(f.Pos()).Filename
+		if wanted[filename] {
+			files = append(files, filename)
+			syntax = append(syntax, f)
+		}
+	}
+	if len(syntax) == 0 || len(syntax) == len(pkg.Syntax) {
+		return
+	}
+
+	pkg.GoFiles, pkg.CompiledGoFiles, pkg.Syntax = files, files, syntax
+	pkg.Errors, pkg.TypeErrors, pkg.IllTyped = nil, nil, false
+	typeCheck(pkg, loadedImporter(pkg.Imports))
+}
+
+// loadedImporter imports the packages go/packages has already loaded, keyed
+// by import path as in packages.Package.Imports.
+type loadedImporter map[string]*packages.Package
+
+func (imports loadedImporter) Import(path string) (*types.Package, error) {
+	if imported := imports[path]; imported != nil && imported.Types != nil {
+		return imported.Types, nil
+	}
+	return nil, fmt.Errorf("package %s was not loaded", path)
+}
+
+func (ts *ThreatSpec) loadBuildPackages(fset *token.FileSet, dirs []string, wanted map[string]bool) ([]*packages.Package, error) {
+	ctx := ts.buildContext()
+	pkgs := make([]*packages.Package, 0)
+	diagnostics := make(Diagnostics, 0)
+
+	for _, dir := range dirs {
+		importPath := ""
+		if bp, err := ctx.ImportDir(dir, build.ImportComment); err == nil && bp.ImportPath != "." {
+			importPath = bp.ImportPath
+		}
+
+		byName := make(map[string]*packages.Package)
+		names := make([]string, 0)
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			filename := filepath.Join(dir, entry.Name())
+			if !wanted[filename] {
+				continue
+			}
+			if match, err := ctx.MatchFile(dir, entry.Name()); err != nil {
+				return nil, err
+			} else if !match {
+				continue
+			}
+
+			f, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+			if err != nil {
+				diagnostics = append(diagnostics, ToDiagnostics(filename, err)...)
+			}
+			if f == nil {
+				continue
+			}
+
+			name := f.Name.Name
+			pkg, ok := byName[name]
+			if !ok {
+				pkgPath := name
+				if importPath != "" {
+					pkgPath = importPath
+					if strings.HasSuffix(name, "_test") && filepath.Base(importPath) != name {
+						pkgPath = importPath + "_test"
+					}
+				}
+				pkg = &packages.Package{
+					ID:      pkgPath,
+					Name:    name,
+					PkgPath: pkgPath,
+					Fset:    fset,
+				}
+				byName[name] = pkg
+				names = append(names, name)
+			}
+			pkg.GoFiles = append(pkg.GoFiles, filename)
+			pkg.Syntax = append(pkg.Syntax, f)
+		}
+
+		for _, name := range names {
+			if ts.Options.CallFlow {
+				typeCheck(byName[name], importer.ForCompiler(fset, "source", nil))
+			}
+			pkgs = append(pkgs, byName[name])
+		}
+	}
+
+	if len(diagnostics) > 0 {
+		return pkgs, diagnostics
+	}
+	return pkgs, nil
+}
+
+// typeCheck fills in the type information go/packages would have provided,
+// resolving imports with imports. Errors are recorded on the package rather
+// than returned, as with packages.Load.
+func typeCheck(pkg *packages.Package, imports types.Importer) {
+	pkg.TypesInfo = &types.Info{
+		Types:        make(map[ast.Expr]types.TypeAndValue),
+		Defs:         make(map[*ast.Ident]types.Object),
+		Uses:         make(map[*ast.Ident]types.Object),
+		Implicits:    make(map[ast.Node]types.Object),
+		Instances:    make(map[*ast.Ident]types.Instance),
+		Scopes:       make(map[ast.Node]*types.Scope),
+		Selections:   make(map[*ast.SelectorExpr]*types.Selection),
+		FileVersions: make(map[*ast.File]string),
+	}
+
+	config := &types.Config{
+		Importer: imports,
+		Error: func(err error) {
+			if typeErr, ok := err.(types.Error); ok {
+				pkg.TypeErrors = append(pkg.TypeErrors, typeErr)
+			}
+			pkg.IllTyped = true
+		},
+	}
+
+	pkg.Types, _ = config.Check(pkg.PkgPath, pkg.Fset, pkg.Syntax, pkg.TypesInfo)
+}
@@ -0,0 +1,68 @@
+package threatspec
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSourceFilesInModule(t *testing.T) {
+	ts := New("test")
+	ts.Options.CallFlow = true
+
+	filenames := []string{
+		filepath.Join("testdata", "module", "main.go"),
+		filepath.Join("testdata", "module", "store", "store.go"),
+	}
+	if err := ts.ParseSourceFiles(filenames); err != nil {
+		t.Fatalf("ParseSourceFiles: %v", err)
+	}
+
+	exposures := ts.Projects["test"].Exposures["@insufficient_input_validation"]
+	if len(exposures) != 1 || exposures[0].Source.Function != "example.com/wiki.viewHandler" {
+		t.Errorf("exposure not attributed to example.com/wiki.viewHandler: %+v", exposures)
+	}
+
+	want := []*Call{{Source: "example.com/wiki.viewHandler", Destination: "example.com/wiki/store.Load"}}
+	if !reflect.DeepEqual(ts.CallFlow, want) {
+		t.Errorf("CallFlow = %+v, want %+v", ts.CallFlow, want)
+	}
+}
+
+// A directory of programs that each declare main only type checks file by
+// file.
+func TestParseSourceFilesNarrowsPackage(t *testing.T) {
+	ts := New("test")
+	ts.Options.CallFlow = true
+
+	filenames := []string{
+		filepath.Join("testdata", "module", "cmd", "export.go"),
+		filepath.Join("testdata", "module", "store", "store.go"),
+	}
+	if err := ts.ParseSourceFiles(filenames); err != nil {
+		t.Fatalf("ParseSourceFiles: %v", err)
+	}
+
+	want := []*Call{{Source: "example.com/wiki/cmd.export", Destination: "example.com/wiki/store.Load"}}
+	if !reflect.DeepEqual(ts.CallFlow, want) {
+		t.Errorf("CallFlow = %+v, want %+v", ts.CallFlow, want)
+	}
+}
+
+func TestParseSourceFilesIllTyped(t *testing.T) {
+	ts := New("test")
+	ts.Options.CallFlow = true
+
+	err := ts.ParseSourceFiles([]string{filepath.Join("testdata", "module", "broken", "broken.go")})
+	diagnostics, ok := err.(Diagnostics)
+	if !ok || len(diagnostics) != 1 || diagnostics.HasErrors() {
+		t.Fatalf("ParseSourceFiles = %v, want one warning", err)
+	}
+	if message := diagnostics[0].Message; !strings.Contains(message, "example.com/wiki/broken") || !strings.Contains(message, "undefinedTitle") {
+		t.Errorf("warning %q does not name the package and its type error", message)
+	}
+	if len(ts.Projects["test"].Exposures) != 1 {
+		t.Errorf("annotations of an ill-typed package were not parsed")
+	}
+}
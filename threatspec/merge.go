@@ -0,0 +1,326 @@
+package threatspec
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// MergePolicy decides what happens when two specs define the same boundary,
+// component or threat id differently.
+type MergePolicy int
+
+const (
+	PreferLeft     MergePolicy = iota // keep the definition already present
+	PreferRight                       // take the definition being merged in
+	FailOnConflict                    // merge nothing and report the conflicts
+)
+
+var mergePolicyNames = map[MergePolicy]string{
+	PreferLeft:     "prefer-left",
+	PreferRight:    "prefer-right",
+	FailOnConflict: "fail",
+}
+
+func (p MergePolicy) String() string {
+	return mergePolicyNames[p]
+}
+
+// ParseMergePolicy parses prefer-left, prefer-right or fail.
+func ParseMergePolicy(name string) (MergePolicy, error) {
+	for policy, policyName := range mergePolicyNames {
+		if name == policyName {
+			return policy, nil
+		}
+	}
+	return PreferLeft, fmt.Errorf("unknown merge policy %q, expected prefer-left, prefer-right or fail", name)
+}
+
+// definitionDifferences lists the fields in which two boundaries, components
+// or threats differ, with both values, such as severity "high" and "low".
+// Empty and missing lists are the same, as they are written alike.
+func definitionDifferences(left, right interface{}) []string {
+	lv, rv := reflect.ValueOf(left).Elem(), reflect.ValueOf(right).Elem()
+	differences := make([]string, 0)
+
+	for i := 0; i < lv.NumField(); i++ {
+		l, r := lv.Field(i), rv.Field(i)
+		if l.Kind() == reflect.Slice && l.Len() == 0 && r.Len() == 0 {
+			continue
+		}
+		if reflect.DeepEqual(l.Interface(), r.Interface()) {
+			continue
+		}
+		name := strings.Split(lv.Type().Field(i).Tag.Get("json"), ",")[0]
+		differences = append(differences, fmt.Sprintf("%s %q and %q", name, l.Interface(), r.Interface()))
+	}
+
+	return differences
+}
+
+func conflictDiagnostic(policy MergePolicy, class string, id Id, differences []string) *Diagnostic {
+	severity := SeverityWarning
+	resolution := "keeping the existing definition"
+	switch policy {
+	case PreferRight:
+		resolution = "using the incoming definition"
+	case FailOnConflict:
+		severity = SeverityError
+		resolution = "not merged"
+	}
+
+	return &Diagnostic{
+		Severity: severity,
+		Kind:     class,
+		Message:  fmt.Sprintf("conflicting definitions of %s %s: %s, %s", class, id, strings.Join(differences, ", "), resolution),
+	}
+}
+
+func sortedIds(ids map[Id]bool) []Id {
+	sorted := make([]Id, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// conflicts returns a diagnostic for every boundary, component and threat id
+// that other defines differently from ts.
+func (ts *ThreatSpec) conflicts(other *ThreatSpec, policy MergePolicy) Diagnostics {
+	diagnostics := make(Diagnostics, 0)
+	check := func(class string, ids map[Id]bool, definitions func(id Id) (interface{}, interface{})) {
+		for _, id := range sortedIds(ids) {
+			left, right := definitions(id)
+			if differences := definitionDifferences(left, right); len(differences) > 0 {
+				diagnostics = append(diagnostics, conflictDiagnostic(policy, class, id, differences))
+			}
+		}
+	}
+
+	ids := make(map[Id]bool)
+	for id := range other.Boundaries {
+		if _, ok := ts.Boundaries[id]; ok {
+			ids[id] = true
+		}
+	}
+	check("boundary", ids, func(id Id) (interface{}, interface{}) {
+		return ts.Boundaries[id], other.Boundaries[id]
+	})
+
+	ids = make(map[Id]bool)
+	for id := range other.Components {
+		if _, ok := ts.Components[id]; ok {
+			ids[id] = true
+		}
+	}
+	check("component", ids, func(id Id) (interface{}, interface{}) {
+		return ts.Components[id], other.Components[id]
+	})
+
+	ids = make(map[Id]bool)
+	for id := range other.Threats {
+		if _, ok := ts.Threats[id]; ok {
+			ids[id] = true
+		}
+	}
+	check("threat", ids, func(id Id) (interface{}, interface{}) {
+		return ts.Threats[id], other.Threats[id]
+	})
+
+	return diagnostics
+}
+
+// Merge adds the contents of other to ts. Identical mitigations, exposures,
+// transfers, acceptances and calls are only kept once. Boundaries, components
+// and threats defined differently under the same id are resolved by policy
+// and reported as warnings; with FailOnConflict they are reported as errors
+// and ts is left unchanged. The merged document keeps the earliest Created
+// and the latest Updated timestamps. Risk scores are dropped, as they need to
+// be computed again with Score, unless ts was empty. Everything taken from
+// other is copied, so other is left unchanged by later changes to ts.
+func (ts *ThreatSpec) Merge(other *ThreatSpec, policy MergePolicy) error {
+	conflicts := ts.conflicts(other, policy)
+	if policy == FailOnConflict && len(conflicts) > 0 {
+		return conflicts
+	}
+	empty := len(ts.Projects) == 0
+	other = deepCopy(reflect.ValueOf(other)).Interface().(*ThreatSpec)
+
+	if ts.Specification == nil {
+		ts.Specification = other.Specification
+	}
+	if ts.Document == nil {
+		ts.Document = other.Document
+	} else if other.Document != nil {
+		if ts.Document.Created == 0 || (other.Document.Created != 0 && other.Document.Created < ts.Document.Created) {
+			ts.Document.Created = other.Document.Created
+		}
+		if other.Document.Updated > ts.Document.Updated {
+			ts.Document.Updated = other.Document.Updated
+		}
+	}
+
+	if ts.Boundaries == nil {
+		ts.Boundaries = make(map[Id]*Boundary)
+	}
+	for id, boundary := range other.Boundaries {
+		if _, ok := ts.Boundaries[id]; !ok || policy == PreferRight {
+			ts.Boundaries[id] = boundary
+		}
+	}
+
+	if ts.Components == nil {
+		ts.Components = make(map[Id]*Component)
+	}
+	for id, component := range other.Components {
+		if _, ok := ts.Components[id]; !ok || policy == PreferRight {
+			ts.Components[id] = component
+		}
+	}
+
+	if ts.Threats == nil {
+		ts.Threats = make(map[Id]*Threat)
+	}
+	for id, threat := range other.Threats {
+		if _, ok := ts.Threats[id]; !ok || policy == PreferRight {
+			ts.Threats[id] = threat
+		}
+	}
+
+	for name, project := range other.Projects {
+		ts.AddProject(name).merge(project)
+	}
+
+	for _, call := range other.CallFlow {
+		duplicate := false
+		for _, existing := range ts.CallFlow {
+			if *existing == *call {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			ts.CallFlow = append(ts.CallFlow, call)
+		}
+	}
+
+	// Scores only still reflect the annotations when merging into an empty spec
+	if empty {
+		ts.Scoring = other.Scoring
+		for name, project := range other.Projects {
+			if project != nil {
+				ts.Projects[name].Risks = project.Risks
+			}
+		}
+	} else {
+		ts.Scoring = ""
+		for _, project := range ts.Projects {
+			project.Risks = nil
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return conflicts
+	}
+	return nil
+}
+
+// merge adds the annotations of other to p, skipping any that p already
+// holds under the same id.
+func (p *Project) merge(other *Project) {
+	if other == nil {
+		return
+	}
+
+	for id, mitigations := range other.Mitigations {
+		for _, mitigation := range mitigations {
+			if !containsEqual(p.Mitigations[id], mitigation) {
+				p.AddMitigation(id, mitigation)
+			}
+		}
+	}
+	for id, exposures := range other.Exposures {
+		for _, exposure := range exposures {
+			if !containsEqual(p.Exposures[id], exposure) {
+				p.AddExposure(id, exposure)
+			}
+		}
+	}
+	for id, transfers := range other.Transfers {
+		for _, transfer := range transfers {
+			if !containsEqual(p.Transfers[id], transfer) {
+				p.AddTransfer(id, transfer)
+			}
+		}
+	}
+	for id, acceptances := range other.Acceptances {
+		for _, acceptance := range acceptances {
+			if !containsEqual(p.Acceptances[id], acceptance) {
+				p.AddAcceptance(id, acceptance)
+			}
+		}
+	}
+}
+
+// containsEqual reports whether list, a slice of pointers, holds an element
+// deeply equal to item.
+func containsEqual(list interface{}, item interface{}) bool {
+	values := reflect.ValueOf(list)
+	for i := 0; i < values.Len(); i++ {
+		if reflect.DeepEqual(values.Index(i).Interface(), item) {
+			return true
+		}
+	}
+	return false
+}
+
+// deepCopy copies the pointers, slices and maps reachable from v, keeping nil
+// and empty values apart. Unexported fields are copied shallowly.
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(deepCopy(v.Elem()))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopy(v.Elem()))
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(deepCopy(v.Field(i)))
+			}
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, key := range v.MapKeys() {
+			c.SetMapIndex(key, deepCopy(v.MapIndex(key)))
+		}
+		return c
+	}
+	return v
+}
@@ -0,0 +1,193 @@
+package threatspec
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mergeFixture(created int64, threat *Threat, exposure string) *ThreatSpec {
+	ts := New("wiki")
+	ts.Document.Created = created
+	ts.Document.Updated = created
+	ts.AddBoundary("", "WebApp")
+	ts.AddComponent("", "App")
+	ts.Threats["@xss"] = threat
+	ts.AddExposure(ts.ToId(exposure), &Exposure{
+		Exposure:  exposure,
+		Boundary:  "@webapp",
+		Component: "@app",
+		Threat:    "@xss",
+		Source:    &Source{Function: "main.editHandler", File: "simple.go", Line: 54},
+	})
+	return ts
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    MergePolicy
+		right     *Threat
+		exposure  string
+		err       string
+		threat    string
+		exposures int
+	}{
+		{"identical", FailOnConflict, &Threat{Name: "XSS"}, "unescaped output", "", "XSS", 1},
+		{"empty and missing lists", FailOnConflict, &Threat{Name: "XSS", Stride: []string{}}, "unescaped output", "", "XSS", 1},
+		{"new exposure", FailOnConflict, &Threat{Name: "XSS"}, "raw templates", "", "XSS", 2},
+		{"prefer left", PreferLeft, &Threat{Name: "Cross site scripting"}, "raw templates",
+			`warning: conflicting definitions of threat @xss: name "XSS" and "Cross site scripting", keeping the existing definition`, "XSS", 2},
+		{"prefer right", PreferRight, &Threat{Name: "Cross site scripting"}, "raw templates",
+			`warning: conflicting definitions of threat @xss: name "XSS" and "Cross site scripting", using the incoming definition`, "Cross site scripting", 2},
+		{"fail", FailOnConflict, &Threat{Name: "XSS", Severity: "high"}, "raw templates",
+			`conflicting definitions of threat @xss: severity "" and "high", not merged`, "XSS", 1},
+	}
+
+	for _, test := range tests {
+		left := mergeFixture(200, &Threat{Name: "XSS"}, "unescaped output")
+		right := mergeFixture(100, test.right, test.exposure)
+		right.Document.Updated = 300
+
+		err := left.Merge(right, test.policy)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: Merge: %v", test.name, err)
+		} else if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("%s: Merge = %v, want %s", test.name, err, test.err)
+		}
+
+		if name := left.Threats["@xss"].Name; name != test.threat {
+			t.Errorf("%s: threat name = %q, want %q", test.name, name, test.threat)
+		}
+		count := 0
+		for _, exposures := range left.Projects["wiki"].Exposures {
+			count += len(exposures)
+		}
+		if count != test.exposures {
+			t.Errorf("%s: %d exposures, want %d", test.name, count, test.exposures)
+		}
+
+		// A failed merge leaves the spec unchanged
+		created, updated := int64(100), int64(300)
+		if test.policy == FailOnConflict && test.err != "" {
+			created, updated = 200, 200
+		}
+		if left.Document.Created != created || left.Document.Updated != updated {
+			t.Errorf("%s: document = %+v, want created %d and updated %d", test.name, left.Document, created, updated)
+		}
+	}
+}
+
+func TestParseMergePolicy(t *testing.T) {
+	for _, policy := range []MergePolicy{PreferLeft, PreferRight, FailOnConflict} {
+		if parsed, err := ParseMergePolicy(policy.String()); err != nil || parsed != policy {
+			t.Errorf("ParseMergePolicy(%q) = %v, %v", policy, parsed, err)
+		}
+	}
+	if _, err := ParseMergePolicy("newest"); err == nil {
+		t.Error("ParseMergePolicy accepted an unknown policy")
+	}
+}
+
+// A spec merged with a copy of itself read back from JSON has nothing to
+// report, even though empty lists are not written out.
+func TestMergeRegenerated(t *testing.T) {
+	ts := New("wiki")
+	if _, threat, err := ParseThreatAttributes("XSS [references: ; stride: t]"); err != nil {
+		t.Fatal(err)
+	} else {
+		ts.Threats["@xss"] = threat
+	}
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "spec.json")
+	writeFiles(t, dir, map[string]string{"spec.json": ts.ToJson()})
+
+	if err := ts.LoadFile(filename); err != nil {
+		t.Errorf("merging a spec with itself: %v", err)
+	}
+
+	copied := new(ThreatSpec)
+	if err := json.Unmarshal([]byte(ts.ToJson()), copied); err != nil {
+		t.Fatal(err)
+	}
+	copied.Threats["@xss"].Cwe = []string{"CWE-79"}
+	if err := ts.Merge(copied, FailOnConflict); err == nil || !strings.Contains(err.Error(), `cwe [] and ["CWE-79"]`) {
+		t.Errorf("Merge = %v, want the differing cwe named", err)
+	}
+}
+
+func TestLoadLibrary(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"library.json": `{"threats": {"@xss": {"name": "XSS", "severity": "high"}}}`,
+		"invalid.json": `{"threats": {"@xss": {"name": "XSS", "severity": "extreme"}}}`,
+	})
+
+	if _, err := Load(filepath.Join(dir, "library.json")); err == nil {
+		t.Error("Load accepted a partial spec")
+	}
+	if _, err := LoadLibrary(filepath.Join(dir, "invalid.json")); err == nil {
+		t.Error("LoadLibrary accepted an invalid severity")
+	}
+
+	ts := New("wiki")
+	if err := ts.LoadFile(filepath.Join(dir, "library.json")); err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	if threat := ts.Threats["@xss"]; threat == nil || threat.Severity != "high" {
+		t.Errorf("library threat = %+v", threat)
+	}
+	if err := ts.Validate(); err != nil {
+		t.Errorf("merged spec is not valid: %v", err)
+	}
+}
+
+func TestLibrarySchema(t *testing.T) {
+	var strict, library map[string]interface{}
+	if err := json.Unmarshal([]byte(ThreatSpecSchemaStrictv0), &strict); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(ThreatSpecSchemaLibraryv0), &library); err != nil {
+		t.Fatalf("library schema is not JSON: %v", err)
+	}
+
+	if _, ok := library["required"]; ok {
+		t.Error("library schema requires top level sections")
+	}
+	delete(strict, "required")
+	delete(strict, "title")
+	delete(library, "title")
+	if !reflect.DeepEqual(strict, library) {
+		t.Error("library schema differs from the strict schema in more than its required sections")
+	}
+}
+
+// Changes to the merged spec, such as scoring it, leave the inputs alone.
+func TestMergeCopies(t *testing.T) {
+	left := mergeFixture(200, &Threat{Name: "XSS", Stride: []string{"tampering"}}, "unescaped output")
+	right := mergeFixture(100, &Threat{Name: "XSS", Stride: []string{"tampering"}}, "raw templates")
+	right.CallFlow = []*Call{{Source: "main.main", Destination: "main.editHandler"}}
+	want := []string{left.ToJson(), right.ToJson()}
+
+	merged := new(ThreatSpec)
+	for _, spec := range []*ThreatSpec{left, right} {
+		if err := merged.Merge(spec, PreferRight); err != nil {
+			t.Fatalf("Merge: %v", err)
+		}
+	}
+	merged.EnsureScores()
+	merged.Document.Created = 0
+	merged.Boundaries["@webapp"].Name = "Changed"
+	merged.Threats["@xss"].Stride[0] = "spoofing"
+	merged.Projects["wiki"].Exposures["@unescaped_output"][0].Source.Line = 1
+	merged.CallFlow[0].Source = "main.init"
+
+	for i, spec := range []*ThreatSpec{left, right} {
+		if got := spec.ToJson(); got != want[i] {
+			t.Errorf("input %d changed by changes to the merged spec:\n%s\nwant\n%s", i, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package threatspec
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var datePattern = regexp.MustCompile(`\b[0-9]{4}-[0-9]{2}-[0-9]{2}\b`)
+
+// stringList accepts either a single string or a list of strings.
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*l = list
+		return nil
+	}
+
+	var single string
+	if err := unmarshal(&single); err != nil {
+		return err
+	}
+	*l = []string{single}
+	return nil
+}
+
+// PolicyRule is a single gate. The filters select annotations of Kind in
+// the given projects, boundaries, components and threats, where boundaries,
+// components and threats may be given by id or by name, with a threat in
+// one of the Stride categories, of at least MinSeverity, or scored at least
+// MinScore. A threat with no severity, or one that is not defined, is not of
+// any severity, so MinSeverity never selects it; Unclassified selects only
+// such threats, so that a rule can deny them or require them to be rated.
+// Every selected annotation must then satisfy the checks: Deny
+// rejects it outright, RequireReference needs a reference matching the
+// regular expression, and MaxAgeDays needs a YYYY-MM-DD reference no older
+// than the given number of days. Level is error, the default, or warning.
+type PolicyRule struct {
+	Name             string     `yaml:"name"`
+	Description      string     `yaml:"description"`
+	Level            string     `yaml:"level"`
+	Kind             string     `yaml:"kind"`
+	Projects         stringList `yaml:"project"`
+	Boundaries       stringList `yaml:"boundary"`
+	Components       stringList `yaml:"component"`
+	Threats          stringList `yaml:"threat"`
+	Stride           stringList `yaml:"stride"`
+	MinSeverity      string     `yaml:"min_severity"`
+	Unclassified     bool       `yaml:"unclassified"`
+	MinScore         float64    `yaml:"min_score"`
+	Deny             bool       `yaml:"deny"`
+	RequireReference string     `yaml:"require_reference"`
+	MaxAgeDays       int        `yaml:"max_age_days"`
+
+	reference *regexp.Regexp
+}
+
+type Policy struct {
+	Rules []*PolicyRule `yaml:"rules"`
+}
+
+// Violation is an annotation that does not satisfy a rule.
+type Violation struct {
+	Rule       *PolicyRule
+	Annotation *Annotation
+	Message    string
+}
+
+// LoadPolicy reads a YAML, or JSON, policy file and checks its rules.
+func LoadPolicy(filename string) (*Policy, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := new(Policy)
+	if err := yaml.UnmarshalStrict(content, policy); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	for i, rule := range policy.Rules {
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %s", filename, i+1, err)
+		}
+	}
+	return policy, nil
+}
+
+func (r *PolicyRule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+
+	switch r.Level {
+	case "":
+		r.Level = SeverityError
+	case SeverityError, SeverityWarning:
+	default:
+		return fmt.Errorf("%s: level must be error or warning", r.Name)
+	}
+
+	switch r.Kind {
+	case "mitigation", "exposure", "transfer", "acceptance":
+	default:
+		return fmt.Errorf("%s: kind must be mitigation, exposure, transfer or acceptance", r.Name)
+	}
+
+	for i, category := range r.Stride {
+		stride, err := NormaliseStride(category)
+		if err != nil {
+			return fmt.Errorf("%s: %s", r.Name, err)
+		}
+		r.Stride[i] = stride
+	}
+
+	if r.MinSeverity != "" {
+		if _, err := NormaliseRating(r.MinSeverity); err != nil {
+			return fmt.Errorf("%s: min_severity: %s", r.Name, err)
+		}
+		if r.Unclassified {
+			return fmt.Errorf("%s: min_severity and unclassified select different threats, use one or the other", r.Name)
+		}
+	}
+
+	if r.RequireReference != "" {
+		reference, err := regexp.Compile(r.RequireReference)
+		if err != nil {
+			return fmt.Errorf("%s: require_reference: %s", r.Name, err)
+		}
+		r.reference = reference
+	}
+
+	if !r.Deny && r.reference == nil && r.MaxAgeDays == 0 {
+		return fmt.Errorf("%s: rule checks nothing, set deny, require_reference or max_age_days", r.Name)
+	}
+	return nil
+}
+
+// matchesName reports whether want holds id, or name ignoring case. An empty
+// want matches everything.
+func matchesName(want []string, id Id, name string) bool {
+	if len(want) == 0 {
+		return true
+	}
+	for _, w := range want {
+		if w == string(id) || strings.EqualFold(w, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// selects reports whether the rule's filters select annotation.
+func (r *PolicyRule) selects(ts *ThreatSpec, annotation *Annotation) bool {
+	if annotation.Kind != r.Kind || !matchesName(r.Projects, "", annotation.Project) {
+		return false
+	}
+
+	boundary, component, threat := "", "", ""
+	if b := ts.Boundaries[annotation.Boundary]; b != nil {
+		boundary = b.Name
+	}
+	if c := ts.Components[annotation.Component]; c != nil {
+		component = c.Name
+	}
+	t := ts.Threats[annotation.Threat]
+	if t != nil {
+		threat = t.Name
+	}
+
+	if !matchesName(r.Boundaries, annotation.Boundary, boundary) ||
+		!matchesName(r.Components, annotation.Component, component) ||
+		!matchesName(r.Threats, annotation.Threat, threat) {
+		return false
+	}
+
+	if len(r.Stride) > 0 {
+		if t == nil {
+			return false
+		}
+		found := false
+		for _, category := range t.Stride {
+			for _, want := range r.Stride {
+				found = found || category == want
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	classified := t != nil && t.Severity != ""
+	if r.Unclassified && classified {
+		return false
+	}
+	if r.MinSeverity != "" && (!classified || ratingValue(t.Severity) < ratingValue(r.MinSeverity)) {
+		return false
+	}
+
+	if r.MinScore > 0 {
+		risk := ts.Risk(annotation.Project, annotation.Boundary, annotation.Component, annotation.Threat)
+		if risk == nil || risk.Score < r.MinScore {
+			return false
+		}
+	}
+
+	return true
+}
+
+// check returns why annotation fails the rule, or "" if it passes.
+func (r *PolicyRule) check(annotation *Annotation, now time.Time) string {
+	if r.Deny {
+		return fmt.Sprintf("%s is not allowed", annotation.Kind)
+	}
+
+	if r.reference != nil {
+		found := false
+		for _, reference := range annotation.References {
+			found = found || r.reference.MatchString(strings.TrimSpace(reference))
+		}
+		if !found {
+			return fmt.Sprintf("%s has no reference matching %s", annotation.Kind, r.RequireReference)
+		}
+	}
+
+	if r.MaxAgeDays > 0 {
+		var date time.Time
+		for _, reference := range annotation.References {
+			if match := datePattern.FindString(reference); match != "" {
+				if parsed, err := time.Parse("2006-01-02", match); err == nil {
+					date = parsed
+					break
+				}
+			}
+		}
+		if date.IsZero() {
+			return fmt.Sprintf("%s has no YYYY-MM-DD date reference", annotation.Kind)
+		}
+		if expiry := date.AddDate(0, 0, r.MaxAgeDays); now.After(expiry) {
+			return fmt.Sprintf("%s dated %s expired after %d days", annotation.Kind, date.Format("2006-01-02"), r.MaxAgeDays)
+		}
+	}
+
+	return ""
+}
+
+// Evaluate checks every annotation in ts against the policy's rules, scoring
+// the spec first if needed, and returns the violations in rule order.
+func (p *Policy) Evaluate(ts *ThreatSpec, now time.Time) []*Violation {
+	ts.EnsureScores()
+	annotations := ts.Annotations()
+	violations := make([]*Violation, 0)
+
+	for _, rule := range p.Rules {
+		for _, annotation := range annotations {
+			if !rule.selects(ts, annotation) {
+				continue
+			}
+			if message := rule.check(annotation, now); message != "" {
+				violations = append(violations, &Violation{rule, annotation, message})
+			}
+		}
+	}
+
+	return violations
+}
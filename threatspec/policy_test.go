@@ -0,0 +1,60 @@
+package threatspec
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicySeverity(t *testing.T) {
+	ts := New("wiki")
+	ts.AddThreat("@xss", "XSS")
+	ts.Threats["@xss"].Severity = "high"
+	ts.AddThreat("@csrf", "CSRF")
+	ts.Threats["@csrf"].Severity = "low"
+	ts.AddThreat("@spoofing", "Spoofing")
+	for _, threat := range []Id{"@xss", "@csrf", "@spoofing", "@undefined"} {
+		ts.AddExposure(threat+"_exposure", &Exposure{Exposure: "exposure", Boundary: "@webapp", Component: "@app", Threat: threat})
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"policy.yaml": `rules:
+  - name: high
+    kind: exposure
+    min_severity: medium
+    deny: true
+  - name: unclassified
+    kind: exposure
+    unclassified: true
+    deny: true
+`})
+	policy, err := LoadPolicy(filepath.Join(dir, "policy.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]string, 0)
+	for _, violation := range policy.Evaluate(ts, time.Now()) {
+		got = append(got, violation.Rule.Name+" "+string(violation.Annotation.Threat))
+	}
+	// Annotations are in id order
+	want := []string{"high @xss", "unclassified @spoofing", "unclassified @undefined"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("violations = %v, want %v", got, want)
+	}
+}
+
+func TestPolicyUnclassifiedWithMinSeverity(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"policy.yaml": `rules:
+  - name: both
+    kind: exposure
+    min_severity: high
+    unclassified: true
+    deny: true
+`})
+	if _, err := LoadPolicy(filepath.Join(dir, "policy.yaml")); err == nil || !strings.Contains(err.Error(), "use one or the other") {
+		t.Errorf("LoadPolicy = %v, want an error", err)
+	}
+}
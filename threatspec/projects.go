@@ -0,0 +1,129 @@
+package threatspec
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ProjectRule assigns the annotations found in files matching Pattern to
+// Project. A pattern ending in /... matches every file beneath a directory,
+// a directory matches the files directly within it, and anything else is a
+// glob matched against the file's path and name.
+type ProjectRule struct {
+	Pattern string
+	Project string
+}
+
+// ParseProjectRule parses a rule written as "pattern=project" or
+// "pattern -> project", for example "services/billing/...=billing".
+func ParseProjectRule(rule string) (ProjectRule, error) {
+	separator := "="
+	if strings.Contains(rule, "->") {
+		separator = "->"
+	}
+
+	parts := strings.SplitN(rule, separator, 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return ProjectRule{}, fmt.Errorf("invalid project rule %q, expected pattern=project", rule)
+	}
+
+	return ProjectRule{
+		Pattern: strings.TrimSpace(parts[0]),
+		Project: strings.TrimSpace(parts[1]),
+	}, nil
+}
+
+// Matches reports whether filename falls under the rule's pattern. Relative
+// patterns are matched against the path relative to the working directory.
+func (r ProjectRule) Matches(filename string) bool {
+	file := path.Clean(filepath.ToSlash(displayPath(filename)))
+	pattern := filepath.ToSlash(r.Pattern)
+
+	if pattern == "..." || pattern == "./..." {
+		return !path.IsAbs(file)
+	}
+	if strings.HasSuffix(pattern, "/...") {
+		dir := path.Clean(strings.TrimSuffix(pattern, "/..."))
+		return file == dir || strings.HasPrefix(file, dir+"/")
+	}
+
+	pattern = path.Clean(pattern)
+	if ok, _ := path.Match(pattern, path.Dir(file)); ok {
+		return true
+	}
+	return matchesAny(file, []string{pattern})
+}
+
+// ProjectFor returns the project that annotations in filename belong to: the
+// project of the first rule in Options.Projects that matches, otherwise
+// ts.Project.
+func (ts *ThreatSpec) ProjectFor(filename string) string {
+	for _, rule := range ts.Options.Projects {
+		if rule.Matches(filename) {
+			return rule.Project
+		}
+	}
+	return ts.Project
+}
+
+func NewProject() *Project {
+	return &Project{
+		Mitigations: make(map[Id][]*Mitigation),
+		Exposures:   make(map[Id][]*Exposure),
+		Transfers:   make(map[Id][]*Transfer),
+		Acceptances: make(map[Id][]*Acceptance),
+	}
+}
+
+// AddProject returns the named project, creating it if the document does not
+// hold it yet.
+func (ts *ThreatSpec) AddProject(name string) *Project {
+	if ts.Projects == nil {
+		ts.Projects = make(map[string]*Project)
+	}
+	if ts.Projects[name] == nil {
+		ts.Projects[name] = NewProject()
+	}
+	return ts.Projects[name]
+}
+
+// ProjectNames returns the names of the projects in the document, sorted.
+func (ts *ThreatSpec) ProjectNames() []string {
+	names := make([]string, 0, len(ts.Projects))
+	for name := range ts.Projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Project) AddMitigation(id Id, mitigation *Mitigation) {
+	if p.Mitigations == nil {
+		p.Mitigations = make(map[Id][]*Mitigation)
+	}
+	p.Mitigations[id] = append(p.Mitigations[id], mitigation)
+}
+
+func (p *Project) AddExposure(id Id, exposure *Exposure) {
+	if p.Exposures == nil {
+		p.Exposures = make(map[Id][]*Exposure)
+	}
+	p.Exposures[id] = append(p.Exposures[id], exposure)
+}
+
+func (p *Project) AddTransfer(id Id, transfer *Transfer) {
+	if p.Transfers == nil {
+		p.Transfers = make(map[Id][]*Transfer)
+	}
+	p.Transfers[id] = append(p.Transfers[id], transfer)
+}
+
+func (p *Project) AddAcceptance(id Id, acceptance *Acceptance) {
+	if p.Acceptances == nil {
+		p.Acceptances = make(map[Id][]*Acceptance)
+	}
+	p.Acceptances[id] = append(p.Acceptances[id], acceptance)
+}
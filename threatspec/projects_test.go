@@ -0,0 +1,53 @@
+package threatspec
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProjectRules(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"services/billing/billing.threatspec": "@exposes App:Billing to fraud with unsigned invoices\n",
+		"services/auth/auth.threatspec":       "@mitigates App:Auth against spoofing with passwords\n",
+	})
+	chdir(t, dir)
+
+	tests := []struct {
+		rules []string
+		want  []string
+	}{
+		{nil, []string{"platform"}},
+		{[]string{"services/billing/...=billing"}, []string{"billing", "platform"}},
+		{[]string{"services/billing/...=billing", "services/...=shared"}, []string{"billing", "shared"}},
+		{[]string{"*.threatspec -> specs"}, []string{"specs"}},
+	}
+
+	for _, test := range tests {
+		ts := New("platform")
+		for _, rule := range test.rules {
+			projectRule, err := ParseProjectRule(rule)
+			if err != nil {
+				t.Fatalf("ParseProjectRule(%q): %v", rule, err)
+			}
+			ts.Options.Projects = append(ts.Options.Projects, projectRule)
+		}
+
+		if err := ts.Parse([]string{"./..."}); err != nil {
+			t.Errorf("Parse with %q: %v", test.rules, err)
+			continue
+		}
+		if got := ts.ProjectNames(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("projects with %q = %q, want %q", test.rules, got, test.want)
+		}
+	}
+
+	ts := New("platform")
+	if err := ts.ParseSpecFile(filepath.Join("services", "auth", "auth.threatspec")); err != nil {
+		t.Fatal(err)
+	}
+	if len(ts.Projects["platform"].Mitigations) != 1 {
+		t.Errorf("default project does not hold the mitigation: %+v", ts.Projects)
+	}
+}
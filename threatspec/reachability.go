@@ -0,0 +1,184 @@
+package threatspec
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// ErrNoCallFlow is returned by AnalyseReachability for a spec without a call
+// flow, such as one parsed with Options.CallFlow unset, as no path between
+// functions can then be shown.
+var ErrNoCallFlow = errors.New("the spec has no call flow, so reachability cannot be analysed")
+
+// Reachability is the result of checking a single exposure against the
+// call flow. Reachable is true when some path leads from an entry point to
+// the exposed function, and Mitigated when every such path passes through a
+// function that mitigates the same boundary, component and threat, listed in
+// Mitigations. Otherwise Path holds one unmitigated path, starting at an
+// entry point and ending at the exposed function.
+type Reachability struct {
+	Project     string
+	Id          Id
+	Exposure    *Exposure
+	Reachable   bool
+	Mitigated   bool
+	Mitigations []string
+	Path        []string
+}
+
+func isMainOrInit(function string) bool {
+	// Drop the import path, which may itself contain dots
+	name := function[strings.LastIndex(function, "/")+1:]
+	parts := strings.Split(name, ".")
+	if len(parts) != 2 {
+		return false
+	}
+	return parts[1] == "main" || parts[1] == "init"
+}
+
+// EntryPoints returns the functions from which the reachability analysis
+// starts: package main and init functions, and any function that no
+// annotated function calls. The latter covers HTTP handlers and other
+// callbacks that are only invoked by other packages.
+//
+// Exported functions are only entry points when nothing in the call flow
+// calls them, so a mitigation in their caller covers them. Callers outside
+// the parsed code are not seen, so for a library whose exported API is
+// called directly, pass its functions to AnalyseReachability instead.
+func (ts *ThreatSpec) EntryPoints() []string {
+	functions := make(map[string]bool)
+	called := make(map[string]bool)
+
+	for name := range ts.annotatedFunctions() {
+		functions[name] = true
+	}
+	for _, call := range ts.CallFlow {
+		functions[call.Source] = true
+		functions[call.Destination] = true
+		called[call.Destination] = true
+	}
+
+	entries := make([]string, 0)
+	for name := range functions {
+		if !called[name] || isMainOrInit(name) {
+			entries = append(entries, name)
+		}
+	}
+	sort.Strings(entries)
+
+	return entries
+}
+
+// mitigatingFunctions returns the functions in project that mitigate the
+// same boundary, component and threat as exposure.
+func (ts *ThreatSpec) mitigatingFunctions(project *Project, exposure *Exposure) map[string]bool {
+	functions := make(map[string]bool)
+	for _, ms := range project.Mitigations {
+		for _, m := range ms {
+			if m.Source == nil || m.Boundary != exposure.Boundary || m.Component != exposure.Component || m.Threat != exposure.Threat {
+				continue
+			}
+			functions[m.Source.Function] = true
+		}
+	}
+	return functions
+}
+
+// unmitigatedPath searches for a path from entries to target that avoids
+// every function in mitigated, returning nil if there is none. With mitigated
+// empty, it finds any path.
+func (ts *ThreatSpec) unmitigatedPath(entries []string, target string, mitigated map[string]bool) []string {
+	callees := make(map[string][]string)
+	for _, call := range ts.CallFlow {
+		callees[call.Source] = append(callees[call.Source], call.Destination)
+	}
+
+	parents := make(map[string]string)
+	visited := make(map[string]bool)
+	queue := make([]string, 0)
+	for _, entry := range entries {
+		if !mitigated[entry] && !visited[entry] {
+			visited[entry] = true
+			queue = append(queue, entry)
+		}
+	}
+
+	for len(queue) > 0 {
+		function := queue[0]
+		queue = queue[1:]
+
+		if function == target {
+			path := []string{function}
+			for parent, ok := parents[function]; ok; parent, ok = parents[parent] {
+				path = append([]string{parent}, path...)
+			}
+			return path
+		}
+
+		for _, callee := range callees[function] {
+			if !visited[callee] && !mitigated[callee] {
+				visited[callee] = true
+				parents[callee] = function
+				queue = append(queue, callee)
+			}
+		}
+	}
+
+	return nil
+}
+
+// AnalyseReachability checks every exposure with a source function against
+// the call flow, starting from the given entry points, or EntryPoints when
+// entries is empty. Exposures without a source, such as those from
+// .threatspec files, cannot be analysed and are skipped. It returns
+// ErrNoCallFlow when the spec has no call flow.
+func (ts *ThreatSpec) AnalyseReachability(entries []string) ([]*Reachability, error) {
+	if len(ts.CallFlow) == 0 {
+		return nil, ErrNoCallFlow
+	}
+	if len(entries) == 0 {
+		entries = ts.EntryPoints()
+	}
+
+	results := make([]*Reachability, 0)
+
+	for _, projectName := range ts.ProjectNames() {
+		project := ts.Projects[projectName]
+
+		ids := make([]string, 0, len(project.Exposures))
+		for id := range project.Exposures {
+			ids = append(ids, string(id))
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			for _, exposure := range project.Exposures[Id(id)] {
+				if exposure.Source == nil || exposure.Source.Function == "" {
+					continue
+				}
+
+				mitigated := ts.mitigatingFunctions(project, exposure)
+				result := &Reachability{
+					Project:  projectName,
+					Id:       Id(id),
+					Exposure: exposure,
+				}
+				for function := range mitigated {
+					result.Mitigations = append(result.Mitigations, function)
+				}
+				sort.Strings(result.Mitigations)
+
+				result.Reachable = ts.unmitigatedPath(entries, exposure.Source.Function, nil) != nil
+				if result.Reachable {
+					result.Path = ts.unmitigatedPath(entries, exposure.Source.Function, mitigated)
+					result.Mitigated = result.Path == nil
+				}
+
+				results = append(results, result)
+			}
+		}
+	}
+
+	return results, nil
+}
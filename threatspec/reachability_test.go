@@ -0,0 +1,88 @@
+package threatspec
+
+import (
+	"reflect"
+	"testing"
+)
+
+// reachabilityFixture exposes pkg.Store and mitigates it in the functions
+// given, with the call flow given as pairs of source and destination.
+func reachabilityFixture(mitigations []string, calls ...string) *ThreatSpec {
+	ts := New("wiki")
+	ts.AddExposure("@raw", &Exposure{Exposure: "raw", Boundary: "@webapp", Component: "@app", Threat: "@xss", Source: &Source{Function: "example.com/pkg.Store"}})
+	for _, function := range mitigations {
+		ts.AddMitigation("@escape", &Mitigation{Mitigation: "escape", Boundary: "@webapp", Component: "@app", Threat: "@xss", Source: &Source{Function: function}})
+	}
+	for i := 0; i+1 < len(calls); i += 2 {
+		ts.CallFlow = append(ts.CallFlow, &Call{Source: calls[i], Destination: calls[i+1]})
+	}
+	return ts
+}
+
+func TestAnalyseReachability(t *testing.T) {
+	tests := []struct {
+		name        string
+		ts          *ThreatSpec
+		reachable   bool
+		mitigated   bool
+		mitigations []string
+		path        []string
+	}{
+		{
+			name:      "unmitigated",
+			ts:        reachabilityFixture(nil, "example.com/pkg.main", "example.com/pkg.Store"),
+			reachable: true,
+			path:      []string{"example.com/pkg.main", "example.com/pkg.Store"},
+		},
+		{
+			name:        "exported callee mitigated by its caller",
+			ts:          reachabilityFixture([]string{"example.com/pkg.handler"}, "example.com/pkg.handler", "example.com/pkg.Store"),
+			reachable:   true,
+			mitigated:   true,
+			mitigations: []string{"example.com/pkg.handler"},
+		},
+		{
+			name:        "mitigated on one path only",
+			ts:          reachabilityFixture([]string{"example.com/pkg.safe"}, "example.com/pkg.main", "example.com/pkg.safe", "example.com/pkg.safe", "example.com/pkg.Store", "example.com/pkg.main", "example.com/pkg.Store"),
+			reachable:   true,
+			mitigations: []string{"example.com/pkg.safe"},
+			path:        []string{"example.com/pkg.main", "example.com/pkg.Store"},
+		},
+		{
+			name: "uncalled cycle",
+			ts:   reachabilityFixture(nil, "example.com/pkg.main", "example.com/pkg.other", "example.com/pkg.Store", "example.com/pkg.load", "example.com/pkg.load", "example.com/pkg.Store"),
+		},
+	}
+
+	for _, test := range tests {
+		results, err := test.ts.AnalyseReachability(nil)
+		if err != nil || len(results) != 1 {
+			t.Errorf("%s: AnalyseReachability = %v, %v", test.name, results, err)
+			continue
+		}
+		r := results[0]
+		if r.Reachable != test.reachable || r.Mitigated != test.mitigated || !reflect.DeepEqual(r.Mitigations, test.mitigations) || !reflect.DeepEqual(r.Path, test.path) {
+			t.Errorf("%s: reachable %v, mitigated %v by %q via %q, want %v, %v by %q via %q", test.name,
+				r.Reachable, r.Mitigated, r.Mitigations, r.Path, test.reachable, test.mitigated, test.mitigations, test.path)
+		}
+	}
+}
+
+func TestAnalyseReachabilityWithoutCallFlow(t *testing.T) {
+	ts := reachabilityFixture(nil)
+	if _, err := ts.AnalyseReachability(nil); err != ErrNoCallFlow {
+		t.Errorf("AnalyseReachability error = %v, want ErrNoCallFlow", err)
+	}
+}
+
+func TestEntryPoints(t *testing.T) {
+	ts := reachabilityFixture(nil,
+		"example.com/pkg.main", "example.com/pkg.Serve",
+		"example.com/pkg.Serve", "example.com/pkg.Store",
+		"example.com/pkg.Handle", "example.com/pkg.init",
+	)
+	want := []string{"example.com/pkg.Handle", "example.com/pkg.init", "example.com/pkg.main"}
+	if got := ts.EntryPoints(); !reflect.DeepEqual(got, want) {
+		t.Errorf("EntryPoints() = %q, want %q", got, want)
+	}
+}
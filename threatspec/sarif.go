@@ -0,0 +1,369 @@
+package threatspec
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	SarifVersion = "2.1.0"
+	SarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// SarifLog is a SARIF 2.1.0 log, as consumed by code scanning dashboards.
+// Only the parts of the format threatspec needs are modelled.
+type SarifLog struct {
+	Schema  string      `json:"$schema"`
+	Version string      `json:"version"`
+	Runs    []*sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool        sarifTool          `json:"tool"`
+	Invocations []*sarifInvocation `json:"invocations"`
+	Results     []*sarifResult     `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string       `json:"name"`
+	InformationUri string       `json:"informationUri"`
+	Rules          []*sarifRule `json:"rules"`
+}
+
+type sarifInvocation struct {
+	ExecutionSuccessful bool `json:"executionSuccessful"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifRule struct {
+	Id                   string                 `json:"id"`
+	Name                 string                 `json:"name,omitempty"`
+	ShortDescription     sarifMessage           `json:"shortDescription"`
+	FullDescription      *sarifMessage          `json:"fullDescription,omitempty"`
+	HelpUri              string                 `json:"helpUri,omitempty"`
+	DefaultConfiguration sarifConfiguration     `json:"defaultConfiguration"`
+	Properties           map[string]interface{} `json:"properties,omitempty"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifResult struct {
+	RuleId              string                 `json:"ruleId"`
+	RuleIndex           int                    `json:"ruleIndex"`
+	Level               string                 `json:"level"`
+	Message             sarifMessage           `json:"message"`
+	Locations           []*sarifLocation       `json:"locations,omitempty"`
+	PartialFingerprints map[string]string      `json:"partialFingerprints,omitempty"`
+	Suppressions        []*sarifSuppression    `json:"suppressions,omitempty"`
+	Properties          map[string]interface{} `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation  `json:"physicalLocation,omitempty"`
+	LogicalLocations []*sarifLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	Uri       string `json:"uri"`
+	UriBaseId string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind,omitempty"`
+}
+
+type sarifSuppression struct {
+	Kind          string `json:"kind"`
+	Justification string `json:"justification,omitempty"`
+}
+
+// Rules for problems with the inputs rather than with the threat model
+var diagnosticRules = map[string]*sarifRule{
+	"annotation": {
+		Id:                   "annotation",
+		Name:                 "MalformedAnnotation",
+		ShortDescription:     sarifMessage{"ThreatSpec annotation could not be parsed"},
+		DefaultConfiguration: sarifConfiguration{"error"},
+	},
+	"input": {
+		Id:                   "input",
+		Name:                 "InputProblem",
+		ShortDescription:     sarifMessage{"Problem reading or merging a ThreatSpec input"},
+		DefaultConfiguration: sarifConfiguration{"error"},
+	},
+}
+
+// sarifLevel maps a threat's severity to a SARIF level. Threats without a
+// severity are warnings.
+func sarifLevel(severity string) string {
+	switch strings.ToLower(severity) {
+	case "critical", "high":
+		return "error"
+	case "low":
+		return "note"
+	}
+	return "warning"
+}
+
+// securitySeverity maps a threat's severity to the 0 to 10 scale code
+// scanning dashboards use to rank security results.
+func securitySeverity(severity string) string {
+	switch strings.ToLower(severity) {
+	case "low":
+		return "2.0"
+	case "high":
+		return "7.5"
+	case "critical":
+		return "9.5"
+	}
+	return "5.0"
+}
+
+// threatRuleId derives a rule id from a threat id, dropping the leading @.
+func threatRuleId(threat Id) string {
+	return strings.TrimPrefix(string(threat), "@")
+}
+
+func (ts *ThreatSpec) threatRule(id Id) *sarifRule {
+	rule := &sarifRule{
+		Id:                   threatRuleId(id),
+		ShortDescription:     sarifMessage{ts.ThreatName(id)},
+		DefaultConfiguration: sarifConfiguration{"warning"},
+		Properties:           map[string]interface{}{"security-severity": securitySeverity("")},
+	}
+
+	threat := ts.Threats[id]
+	if threat == nil {
+		return rule
+	}
+
+	rule.Name = threat.Name
+	if threat.Description != "" {
+		rule.FullDescription = &sarifMessage{threat.Description}
+	}
+	rule.DefaultConfiguration.Level = sarifLevel(threat.Severity)
+	rule.Properties["security-severity"] = securitySeverity(threat.Severity)
+
+	tags := []string{"security"}
+	for _, category := range threat.Stride {
+		tags = append(tags, "stride/"+strings.ToLower(strings.Replace(category, " ", "-", -1)))
+	}
+	for _, cwe := range threat.Cwe {
+		tags = append(tags, "external/cwe/"+strings.ToLower(cwe))
+	}
+	rule.Properties["tags"] = tags
+
+	for _, reference := range threat.References {
+		if strings.HasPrefix(reference, "http://") || strings.HasPrefix(reference, "https://") {
+			rule.HelpUri = reference
+			break
+		}
+	}
+	if rule.HelpUri == "" {
+		for _, ref := range append(append([]string{}, threat.Cwe...), threat.Capec...) {
+			if entry := LookupCatalog(ref); entry != nil {
+				rule.HelpUri = entry.Url
+				break
+			}
+		}
+	}
+
+	return rule
+}
+
+// sarifLogicalKinds maps the kind of declaration carrying an annotation to
+// a SARIF logical location kind.
+var sarifLogicalKinds = map[string]string{
+	"":         "function",
+	"function": "function",
+	"type":     "type",
+	"field":    "member",
+	"var":      "variable",
+	"const":    "variable",
+	"package":  "namespace",
+}
+
+// sarifArtifact locates file relative to %SRCROOT%, taken to be the working
+// directory, or by an absolute file URI when it lies outside of it.
+func sarifArtifact(file string) sarifArtifactLocation {
+	if abs, err := filepath.Abs(file); err == nil {
+		if wd, err := os.Getwd(); err == nil {
+			rel, err := filepath.Rel(wd, abs)
+			if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return sarifArtifactLocation{Uri: filepath.ToSlash(rel), UriBaseId: "%SRCROOT%"}
+			}
+		}
+		file = abs
+	}
+
+	path := filepath.ToSlash(file)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return sarifArtifactLocation{Uri: (&url.URL{Scheme: "file", Path: path}).String()}
+}
+
+func sarifSourceLocation(source *Source) *sarifLocation {
+	if source == nil || source.File == "" {
+		return nil
+	}
+
+	location := &sarifLocation{
+		PhysicalLocation: &sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifact(source.File),
+		},
+	}
+	if source.Line > 0 {
+		location.PhysicalLocation.Region = &sarifRegion{StartLine: source.Line, StartColumn: source.Column}
+	}
+	if source.Function != "" {
+		location.LogicalLocations = []*sarifLogicalLocation{{source.Function, sarifLogicalKinds[source.Kind]}}
+	}
+	return location
+}
+
+// ToSarif reports the exposures in ts, the threats left unmitigated, and the
+// problems found while parsing its inputs as a SARIF log. Exposures whose
+// risk was accepted are reported as suppressed.
+func (ts *ThreatSpec) ToSarif(diagnostics Diagnostics) *SarifLog {
+	rules := make([]*sarifRule, 0)
+	ruleIndex := make(map[string]int)
+	result := func(rule *sarifRule, level string, message string) *sarifResult {
+		index, ok := ruleIndex[rule.Id]
+		if !ok {
+			index = len(rules)
+			ruleIndex[rule.Id] = index
+			rules = append(rules, rule)
+		}
+		return &sarifResult{RuleId: rule.Id, RuleIndex: index, Level: level, Message: sarifMessage{message}}
+	}
+
+	results := make([]*sarifResult, 0)
+
+	for _, diagnostic := range diagnostics {
+		rule := diagnosticRules["input"]
+		if diagnostic.Kind != "" {
+			rule = diagnosticRules["annotation"]
+		}
+		message := diagnostic.Message
+		if diagnostic.Suggestion != "" {
+			message = fmt.Sprintf("%s. Did you mean: %s", message, diagnostic.Suggestion)
+		}
+
+		r := result(rule, diagnostic.Severity, message)
+		if location := sarifSourceLocation(&Source{File: diagnostic.File, Line: diagnostic.Line, Column: diagnostic.Column}); location != nil {
+			r.Locations = []*sarifLocation{location}
+		}
+		results = append(results, r)
+	}
+
+	// Acceptances justify the exposures of the same boundary, component and
+	// threat
+	type projectRisk struct {
+		project string
+		riskKey
+	}
+	accepted := make(map[projectRisk]string)
+	for _, annotation := range ts.Annotations() {
+		if annotation.Kind == "acceptance" {
+			accepted[projectRisk{annotation.Project, riskKey{annotation.Boundary, annotation.Component, annotation.Threat}}] = annotation.Text
+		}
+	}
+
+	exposed := make(map[projectRisk][]*sarifLocation)
+	for _, annotation := range ts.Annotations() {
+		if annotation.Kind != "exposure" {
+			continue
+		}
+
+		rule := ts.threatRule(annotation.Threat)
+		r := result(rule, rule.DefaultConfiguration.Level, fmt.Sprintf("%s:%s is exposed to %s by %s",
+			ts.BoundaryName(annotation.Boundary),
+			ts.ComponentName(annotation.Component),
+			ts.ThreatName(annotation.Threat),
+			annotation.Text))
+		r.PartialFingerprints = map[string]string{"threatspec/v1": annotation.Fingerprint()}
+		r.Properties = map[string]interface{}{"kind": "exposure", "project": annotation.Project}
+		if risk := ts.Risk(annotation.Project, annotation.Boundary, annotation.Component, annotation.Threat); risk != nil {
+			r.Properties["score"] = risk.Score
+			r.Properties["risk"] = risk.Level
+		}
+
+		key := projectRisk{annotation.Project, riskKey{annotation.Boundary, annotation.Component, annotation.Threat}}
+		if justification, ok := accepted[key]; ok {
+			r.Suppressions = []*sarifSuppression{{"inSource", justification}}
+		}
+		if location := sarifSourceLocation(annotation.Source); location != nil {
+			r.Locations = []*sarifLocation{location}
+			exposed[key] = append(exposed[key], location)
+		}
+		results = append(results, r)
+	}
+
+	for _, projectName := range ts.ProjectNames() {
+		for _, input := range ts.riskInputs(ts.Projects[projectName]) {
+			if input.Exposures == 0 || input.Mitigations+input.Transfers+input.Acceptances > 0 {
+				continue
+			}
+			rule := ts.threatRule(input.Threat)
+			r := result(rule, rule.DefaultConfiguration.Level, fmt.Sprintf("%s is not mitigated, transferred or accepted for %s:%s",
+				ts.ThreatName(input.Threat),
+				ts.BoundaryName(input.Boundary),
+				ts.ComponentName(input.Component)))
+			r.Locations = exposed[projectRisk{projectName, riskKey{input.Boundary, input.Component, input.Threat}}]
+			r.PartialFingerprints = map[string]string{"threatspec/v1": Fingerprint(projectName, "unmitigated", input.Boundary, input.Component, input.Threat, nil)}
+			r.Properties = map[string]interface{}{"kind": "unmitigated", "project": projectName}
+			if risk := ts.Risk(projectName, input.Boundary, input.Component, input.Threat); risk != nil {
+				r.Properties["score"] = risk.Score
+				r.Properties["risk"] = risk.Level
+			}
+			results = append(results, r)
+		}
+	}
+
+	return &SarifLog{
+		Schema:  SarifSchema,
+		Version: SarifVersion,
+		Runs: []*sarifRun{{
+			Tool: sarifTool{sarifDriver{
+				Name:           "threatspec-go",
+				InformationUri: "https://github.com/threatspec/threatspec-go",
+				Rules:          rules,
+			}},
+			Invocations: []*sarifInvocation{{!diagnostics.HasErrors()}},
+			Results:     results,
+		}},
+	}
+}
+
+func (l *SarifLog) ToJson() string {
+	dump, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(dump)
+}
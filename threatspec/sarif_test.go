@@ -0,0 +1,57 @@
+package threatspec
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSarifArtifact(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+
+	tests := []struct {
+		file      string
+		uri       string
+		uriBaseId string
+	}{
+		{"simple.go", "simple.go", "%SRCROOT%"},
+		{filepath.Join(dir, "cmd", "main.go"), "cmd/main.go", "%SRCROOT%"},
+		{filepath.Join(filepath.Dir(dir), "other", "main.go"), "file://" + filepath.ToSlash(filepath.Join(filepath.Dir(dir), "other", "main.go")), ""},
+		{"../other/main.go", "file://" + filepath.ToSlash(filepath.Join(filepath.Dir(dir), "other", "main.go")), ""},
+	}
+
+	for _, test := range tests {
+		got := sarifArtifact(test.file)
+		if got.Uri != test.uri || got.UriBaseId != test.uriBaseId {
+			t.Errorf("sarifArtifact(%q) = %q based on %q, want %q based on %q", test.file, got.Uri, got.UriBaseId, test.uri, test.uriBaseId)
+		}
+	}
+}
+
+// Annotations in spec files have no function, but results still point at
+// the line of the spec file that made them.
+func TestSarifSpecFileLocations(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"example.threatspec": "# Exposures\n@exposes WebApp:App to XSS with raw output\n"})
+	chdir(t, dir)
+
+	ts := New("wiki")
+	if err := ts.ParseSpecFile("example.threatspec"); err != nil {
+		t.Fatal(err)
+	}
+
+	results := ts.ToSarif(nil).Runs[0].Results
+	if len(results) != 2 {
+		t.Fatalf("ToSarif gave %d results, want an exposure and an unmitigated threat", len(results))
+	}
+	for _, result := range results {
+		if len(result.Locations) != 1 {
+			t.Errorf("%s result has %d locations, want 1", result.Properties["kind"], len(result.Locations))
+			continue
+		}
+		location := result.Locations[0].PhysicalLocation
+		if location.ArtifactLocation.Uri != "example.threatspec" || location.Region == nil || location.Region.StartLine != 2 {
+			t.Errorf("%s result at %+v, want example.threatspec:2", result.Properties["kind"], location)
+		}
+	}
+}
@@ -1,5 +1,9 @@
 package threatspec
 
+import (
+	"encoding/json"
+)
+
 // Note the major version of the specification version is hardcoded
 // in the below specification
 const ThreatSpecSchemaStrictv0 string = `{
@@ -18,14 +22,36 @@ const ThreatSpecSchemaStrictv0 string = `{
       "items": { "type": "string" },
       "uniqueItems": true
     },
+    "risk": {
+      "type": "object",
+      "required": ["boundary","component","threat","score","level"],
+      "additionalProperties": false,
+      "properties": {
+        "boundary": { "$ref": "#/definitions/id" },
+        "component": { "$ref": "#/definitions/id" },
+        "threat": { "$ref": "#/definitions/id" },
+        "score": { "type": "number", "minimum": 0, "maximum": 10 },
+        "level": { "$ref": "#/definitions/rating" },
+        "exposures": { "type": "integer" },
+        "mitigations": { "type": "integer" },
+        "transferred": { "type": "boolean" },
+        "accepted": { "type": "boolean" }
+      }
+    },
+    "rating": {
+      "type": "string",
+      "enum": ["low", "medium", "high", "critical"]
+    },
     "source": {
       "type": "object",
       "required": ["function","file","line"],
       "additionalProperties": false,
       "properties": {
         "function": { "type": "string" },
+        "kind": { "type": "string", "enum": ["function", "type", "field", "var", "const", "package"] },
         "file": { "type": "string" },
-        "line": { "type": "integer" }
+        "line": { "type": "integer" },
+        "column": { "type": "integer" }
       }
     },
     "call": {
@@ -94,7 +120,25 @@ const ThreatSpecSchemaStrictv0 string = `{
           "properties": {
             "name": { "type": "string" },
             "description": { "type": "string" },
-            "references": { "$ref": "#/definitions/references" }
+            "references": { "$ref": "#/definitions/references" },
+            "stride": {
+              "type": "array",
+              "items": { "type": "string", "enum": ["spoofing", "tampering", "repudiation", "information_disclosure", "denial_of_service", "elevation_of_privilege"] },
+              "uniqueItems": true
+            },
+            "cwe": {
+              "type": "array",
+              "items": { "type": "string", "pattern": "^CWE-[0-9]+$" },
+              "uniqueItems": true
+            },
+            "capec": {
+              "type": "array",
+              "items": { "type": "string", "pattern": "^CAPEC-[0-9]+$" },
+              "uniqueItems": true
+            },
+            "owasp": { "type": "string" },
+            "severity": { "$ref": "#/definitions/rating" },
+            "likelihood": { "$ref": "#/definitions/rating" }
           }
         }
       }
@@ -189,11 +233,16 @@ const ThreatSpecSchemaStrictv0 string = `{
                   }
                 }
               }
+            },
+            "risks": {
+              "type": "array",
+              "items": { "$ref": "#/definitions/risk" }
             }
           }
         }
       }
     },
+    "scoring": { "type": "string" },
     "callflow": {
       "type": "array",
       "items": {
@@ -202,3 +251,25 @@ const ThreatSpecSchemaStrictv0 string = `{
     }
   }
 }`
+
+// ThreatSpecSchemaLibraryv0 is the strict schema without its required
+// sections, for partial documents such as threat libraries that are merged
+// into a full spec.
+var ThreatSpecSchemaLibraryv0 = librarySchema(ThreatSpecSchemaStrictv0)
+
+// librarySchema drops the top level required sections from schema, keeping
+// the requirements on everything within them.
+func librarySchema(schema string) string {
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(schema), &document); err != nil {
+		panic("threatspec: invalid schema: " + err.Error())
+	}
+	document["title"] = "threatspec_schema_library"
+	delete(document, "required")
+
+	library, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		panic("threatspec: invalid schema: " + err.Error())
+	}
+	return string(library)
+}
@@ -0,0 +1,395 @@
+package threatspec
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RiskInput is what a Scorer rates: one boundary, component and threat, the
+// threat's severity and likelihood (1 for low up to 4 for critical, medium
+// when the threat does not say), and how many annotations of each kind
+// concern it.
+type RiskInput struct {
+	Boundary    Id
+	Component   Id
+	Threat      Id
+	Severity    int
+	Likelihood  int
+	Exposures   int
+	Mitigations int
+	Transfers   int
+	Acceptances int
+}
+
+// Risk is the residual risk of a boundary, component and threat, scored from
+// 0 to 10.
+type Risk struct {
+	Boundary    Id      `json:"boundary"`
+	Component   Id      `json:"component"`
+	Threat      Id      `json:"threat"`
+	Score       float64 `json:"score"`
+	Level       string  `json:"level"`
+	Exposures   int     `json:"exposures,omitempty"`
+	Mitigations int     `json:"mitigations,omitempty"`
+	Transferred bool    `json:"transferred,omitempty"`
+	Accepted    bool    `json:"accepted,omitempty"`
+}
+
+// Scorer computes the residual risk, from 0 to 10, of a risk input.
+type Scorer interface {
+	Name() string
+	Score(input *RiskInput) (float64, error)
+}
+
+// residual reduces an inherent score for the controls in place: each
+// mitigation halves the risk, a transfer halves it again and an acceptance
+// lowers it by a quarter, as the risk is owned but still present.
+func residual(inherent float64, input *RiskInput) float64 {
+	score := inherent * math.Pow(0.5, float64(input.Mitigations))
+	if input.Transfers > 0 {
+		score *= 0.5
+	}
+	if input.Acceptances > 0 {
+		score *= 0.75
+	}
+	return score
+}
+
+// LikelihoodImpact scores likelihood × severity, scaled to 0 to 10 and raised
+// by a quarter for each exposure after the first, up to 10. It is reduced for
+// mitigations, transfers and acceptances.
+type LikelihoodImpact struct{}
+
+func (LikelihoodImpact) Name() string {
+	return "likelihood-impact"
+}
+
+func (LikelihoodImpact) Score(input *RiskInput) (float64, error) {
+	inherent := float64(input.Likelihood*input.Severity) * 10 / 16
+	if input.Exposures > 1 {
+		inherent = math.Min(10, inherent*(1+0.25*float64(input.Exposures-1)))
+	}
+	return residual(inherent, input), nil
+}
+
+// Dread averages the five DREAD ratings, derived from the input: damage from
+// severity, reproducibility and exploitability from likelihood, affected
+// users from the number of exposures and discoverability from whether there
+// is any exposure at all. It is reduced for mitigations, transfers and
+// acceptances.
+type Dread struct{}
+
+func (Dread) Name() string {
+	return "dread"
+}
+
+func (Dread) Score(input *RiskInput) (float64, error) {
+	damage := 2.5 * float64(input.Severity)
+	reproducibility := 2.5 * float64(input.Likelihood)
+	exploitability := reproducibility
+	affected := math.Min(10, 2.5*float64(input.Exposures+1))
+	discoverability := 5.0
+	if input.Exposures > 0 {
+		discoverability = 10
+	}
+	return residual((damage+reproducibility+exploitability+affected+discoverability)/5, input), nil
+}
+
+// Formula scores with an arithmetic expression over the variables severity,
+// likelihood, exposures, mitigations, transfers and acceptances, using
+// + - * / and the functions min, max and pow. Accepted and transferred are 1
+// when there is any acceptance or transfer, otherwise 0.
+type Formula struct {
+	Expression string
+	expr       ast.Expr
+}
+
+// NewFormula parses expression, reporting unknown variables and functions
+// and unsupported syntax.
+func NewFormula(expression string) (*Formula, error) {
+	expr, err := parser.ParseExpr(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid formula %q: %s", expression, err)
+	}
+
+	formula := &Formula{Expression: expression, expr: expr}
+	if _, err := formula.evaluate(expr, formulaVariables(&RiskInput{Severity: 2, Likelihood: 2})); err != nil {
+		return nil, fmt.Errorf("invalid formula %q: %s", expression, err)
+	}
+	return formula, nil
+}
+
+func (f *Formula) Name() string {
+	return "formula: " + f.Expression
+}
+
+// Score evaluates the formula, clamping the result to 0 to 10. A result that
+// is not a finite number, as after division by zero, is an error.
+func (f *Formula) Score(input *RiskInput) (float64, error) {
+	score, err := f.evaluate(f.expr, formulaVariables(input))
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(score) || math.IsInf(score, 0) {
+		return 0, fmt.Errorf("formula %q gives %v, as when dividing by zero", f.Expression, score)
+	}
+	return math.Max(0, math.Min(10, score)), nil
+}
+
+func formulaVariables(input *RiskInput) map[string]float64 {
+	flag := func(count int) float64 {
+		if count > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	return map[string]float64{
+		"severity":    float64(input.Severity),
+		"likelihood":  float64(input.Likelihood),
+		"exposures":   float64(input.Exposures),
+		"mitigations": float64(input.Mitigations),
+		"transfers":   float64(input.Transfers),
+		"acceptances": float64(input.Acceptances),
+		"transferred": flag(input.Transfers),
+		"accepted":    flag(input.Acceptances),
+	}
+}
+
+func (f *Formula) evaluate(expr ast.Expr, variables map[string]float64) (float64, error) {
+	switch x := expr.(type) {
+	case *ast.ParenExpr:
+		return f.evaluate(x.X, variables)
+	case *ast.BasicLit:
+		if x.Kind != token.INT && x.Kind != token.FLOAT {
+			return 0, fmt.Errorf("unsupported literal %s", x.Value)
+		}
+		return strconv.ParseFloat(x.Value, 64)
+	case *ast.Ident:
+		if value, ok := variables[x.Name]; ok {
+			return value, nil
+		}
+		return 0, fmt.Errorf("unknown variable %q", x.Name)
+	case *ast.UnaryExpr:
+		value, err := f.evaluate(x.X, variables)
+		if err != nil {
+			return 0, err
+		}
+		switch x.Op {
+		case token.SUB:
+			return -value, nil
+		case token.ADD:
+			return value, nil
+		}
+		return 0, fmt.Errorf("unsupported operator %s", x.Op)
+	case *ast.BinaryExpr:
+		left, err := f.evaluate(x.X, variables)
+		if err != nil {
+			return 0, err
+		}
+		right, err := f.evaluate(x.Y, variables)
+		if err != nil {
+			return 0, err
+		}
+		switch x.Op {
+		case token.ADD:
+			return left + right, nil
+		case token.SUB:
+			return left - right, nil
+		case token.MUL:
+			return left * right, nil
+		case token.QUO:
+			return left / right, nil
+		}
+		return 0, fmt.Errorf("unsupported operator %s", x.Op)
+	case *ast.CallExpr:
+		name, ok := x.Fun.(*ast.Ident)
+		if !ok || len(x.Args) != 2 {
+			return 0, fmt.Errorf("functions take two arguments: min, max or pow")
+		}
+		a, err := f.evaluate(x.Args[0], variables)
+		if err != nil {
+			return 0, err
+		}
+		b, err := f.evaluate(x.Args[1], variables)
+		if err != nil {
+			return 0, err
+		}
+		switch name.Name {
+		case "min":
+			return math.Min(a, b), nil
+		case "max":
+			return math.Max(a, b), nil
+		case "pow":
+			return math.Pow(a, b), nil
+		}
+		return 0, fmt.Errorf("unknown function %q", name.Name)
+	}
+	return 0, fmt.Errorf("unsupported expression %s", types.ExprString(expr))
+}
+
+// ParseScorer returns the scorer named likelihood-impact or dread, or a
+// Formula when formula is given.
+func ParseScorer(name string, formula string) (Scorer, error) {
+	if formula != "" {
+		return NewFormula(formula)
+	}
+
+	switch name {
+	case "", "likelihood-impact":
+		return LikelihoodImpact{}, nil
+	case "dread":
+		return Dread{}, nil
+	}
+	return nil, fmt.Errorf("unknown scoring model %q, expected likelihood-impact or dread", name)
+}
+
+// RiskLevel names a score: low below 2.5, medium below 5, high below 7.5
+// and critical above.
+func RiskLevel(score float64) string {
+	switch {
+	case score < 2.5:
+		return "low"
+	case score < 5:
+		return "medium"
+	case score < 7.5:
+		return "high"
+	}
+	return "critical"
+}
+
+func ratingValue(rating string) int {
+	for i, r := range Ratings {
+		if strings.EqualFold(rating, r) {
+			return i + 1
+		}
+	}
+	return 2
+}
+
+type riskKey struct {
+	boundary  Id
+	component Id
+	threat    Id
+}
+
+// riskInputs collects the annotations of project by boundary, component and
+// threat.
+func (ts *ThreatSpec) riskInputs(project *Project) []*RiskInput {
+	inputs := make(map[riskKey]*RiskInput)
+	input := func(boundary, component, threat Id) *RiskInput {
+		key := riskKey{boundary, component, threat}
+		if inputs[key] == nil {
+			in := &RiskInput{Boundary: boundary, Component: component, Threat: threat, Severity: 2, Likelihood: 2}
+			if t := ts.Threats[threat]; t != nil {
+				in.Severity = ratingValue(t.Severity)
+				in.Likelihood = ratingValue(t.Likelihood)
+			}
+			inputs[key] = in
+		}
+		return inputs[key]
+	}
+
+	for _, ms := range project.Mitigations {
+		for _, m := range ms {
+			input(m.Boundary, m.Component, m.Threat).Mitigations++
+		}
+	}
+	for _, es := range project.Exposures {
+		for _, e := range es {
+			input(e.Boundary, e.Component, e.Threat).Exposures++
+		}
+	}
+	for _, trs := range project.Transfers {
+		for _, t := range trs {
+			input(t.Boundary, t.Component, t.Threat).Transfers++
+		}
+	}
+	for _, as := range project.Acceptances {
+		for _, a := range as {
+			input(a.Boundary, a.Component, a.Threat).Acceptances++
+		}
+	}
+
+	sorted := make([]*RiskInput, 0, len(inputs))
+	for _, in := range inputs {
+		sorted = append(sorted, in)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Boundary != b.Boundary {
+			return a.Boundary < b.Boundary
+		}
+		if a.Component != b.Component {
+			return a.Component < b.Component
+		}
+		return a.Threat < b.Threat
+	})
+	return sorted
+}
+
+// Score computes the residual risk of every boundary, component and threat
+// in each project with scorer, replacing any earlier scores. It stops at the
+// first risk the scorer fails on, leaving the spec unscored.
+func (ts *ThreatSpec) Score(scorer Scorer) error {
+	risks := make(map[*Project][]*Risk)
+
+	for _, project := range ts.Projects {
+		risks[project] = make([]*Risk, 0)
+		for _, input := range ts.riskInputs(project) {
+			score, err := scorer.Score(input)
+			if err != nil {
+				return fmt.Errorf("scoring %s:%s against %s: %s", input.Boundary, input.Component, input.Threat, err)
+			}
+			score = math.Round(score*10) / 10
+			risks[project] = append(risks[project], &Risk{
+				Boundary:    input.Boundary,
+				Component:   input.Component,
+				Threat:      input.Threat,
+				Score:       score,
+				Level:       RiskLevel(score),
+				Exposures:   input.Exposures,
+				Mitigations: input.Mitigations,
+				Transferred: input.Transfers > 0,
+				Accepted:    input.Acceptances > 0,
+			})
+		}
+	}
+
+	ts.Scoring = scorer.Name()
+	for project, projectRisks := range risks {
+		project.Risks = projectRisks
+	}
+	return nil
+}
+
+// Risk returns the scored risk of a boundary, component and threat in the
+// named project, or nil if the spec has not been scored.
+func (ts *ThreatSpec) Risk(projectName string, boundary, component, threat Id) *Risk {
+	project := ts.Projects[projectName]
+	if project == nil {
+		return nil
+	}
+	for _, risk := range project.Risks {
+		if risk.Boundary == boundary && risk.Component == component && risk.Threat == threat {
+			return risk
+		}
+	}
+	return nil
+}
+
+// EnsureScores scores the spec with LikelihoodImpact unless it already
+// carries scores, such as a spec written before scoring was added.
+func (ts *ThreatSpec) EnsureScores() {
+	if ts.Scoring == "" {
+		// LikelihoodImpact never fails
+		ts.Score(LikelihoodImpact{})
+	}
+}
@@ -0,0 +1,77 @@
+package threatspec
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScorers(t *testing.T) {
+	formula := func(expression string) Scorer {
+		f, err := NewFormula(expression)
+		if err != nil {
+			t.Fatalf("NewFormula(%q): %v", expression, err)
+		}
+		return f
+	}
+
+	tests := []struct {
+		name   string
+		scorer Scorer
+		input  RiskInput
+		want   float64
+		err    string
+	}{
+		{"inherent", LikelihoodImpact{}, RiskInput{Severity: 2, Likelihood: 2, Exposures: 1}, 2.5, ""},
+		{"no exposures", LikelihoodImpact{}, RiskInput{Severity: 2, Likelihood: 2}, 2.5, ""},
+		{"critical", LikelihoodImpact{}, RiskInput{Severity: 4, Likelihood: 4, Exposures: 1}, 10, ""},
+		{"more exposures", LikelihoodImpact{}, RiskInput{Severity: 2, Likelihood: 2, Exposures: 3}, 3.75, ""},
+		{"more exposures capped", LikelihoodImpact{}, RiskInput{Severity: 4, Likelihood: 4, Exposures: 3}, 10, ""},
+		{"mitigations", LikelihoodImpact{}, RiskInput{Severity: 4, Likelihood: 4, Exposures: 1, Mitigations: 2}, 2.5, ""},
+		{"transfer", LikelihoodImpact{}, RiskInput{Severity: 2, Likelihood: 2, Exposures: 1, Transfers: 2}, 1.25, ""},
+		{"acceptance", LikelihoodImpact{}, RiskInput{Severity: 2, Likelihood: 2, Exposures: 1, Acceptances: 1}, 1.875, ""},
+		{"dread without exposures", Dread{}, RiskInput{Severity: 2, Likelihood: 2}, 4.5, ""},
+		{"dread capped", Dread{}, RiskInput{Severity: 4, Likelihood: 4, Exposures: 5}, 10, ""},
+		{"dread mitigated and transferred", Dread{}, RiskInput{Severity: 4, Likelihood: 4, Exposures: 5, Mitigations: 1, Transfers: 1}, 2.5, ""},
+		{"formula", formula("severity/(1+mitigations)"), RiskInput{Severity: 4, Mitigations: 1}, 2, ""},
+		{"formula above 10", formula("severity*likelihood"), RiskInput{Severity: 4, Likelihood: 4}, 10, ""},
+		{"formula below 0", formula("-severity"), RiskInput{Severity: 4}, 0, ""},
+		{"division by zero", formula("severity/mitigations"), RiskInput{Severity: 4}, 0, "+Inf"},
+		{"zero by zero", formula("mitigations/transfers"), RiskInput{Severity: 4}, 0, "NaN"},
+	}
+
+	for _, test := range tests {
+		input := test.input
+		got, err := test.scorer.Score(&input)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: Score error = %v, want it to mention %s", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%s: Score = %v, %v, want %v", test.name, got, err, test.want)
+		}
+	}
+}
+
+func TestNewFormulaErrors(t *testing.T) {
+	for _, expression := range []string{"severity +", "impact*likelihood", "sqrt(severity, 2)", `"high"`, "severity % 2"} {
+		if _, err := NewFormula(expression); err == nil {
+			t.Errorf("NewFormula(%q) accepted an invalid formula", expression)
+		}
+	}
+}
+
+// A scorer that fails leaves the spec unscored rather than partly scored.
+func TestScoreError(t *testing.T) {
+	ts := New("wiki")
+	ts.AddExposure("@raw", &Exposure{Exposure: "raw", Boundary: "@webapp", Component: "@app", Threat: "@xss"})
+
+	f, _ := NewFormula("severity/mitigations")
+	if err := ts.Score(f); err == nil || !strings.Contains(err.Error(), "@webapp:@app against @xss") {
+		t.Errorf("Score = %v, want an error naming the risk", err)
+	}
+	if ts.Scoring != "" || ts.Projects["wiki"].Risks != nil {
+		t.Errorf("failed Score left Scoring %q and Risks %v", ts.Scoring, ts.Projects["wiki"].Risks)
+	}
+}
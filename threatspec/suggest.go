@@ -0,0 +1,218 @@
+package threatspec
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var nearTriggerPattern = regexp.MustCompile(`^(?P<indent>\s*)@(?P<word>[a-zA-Z_]+)(?P<body>.*)$`)
+
+var triggerWords = []string{"mitigates", "exposes", "transfers", "accepts", "alias"}
+
+// annotationForms describes each annotation: the keywords that must appear in
+// order after the trigger, which of the segments between them holds the
+// boundary:component pair (-1 for none), the pattern a well formed
+// annotation matches and the form shown to users.
+var annotationForms = map[string]struct {
+	keywords []string
+	pair     int
+	pattern  *regexp.Regexp
+	form     string
+}{
+	"mitigates": {[]string{"against", "with"}, 0, mitigationPattern, "@mitigates Boundary:Component against Threat with Mitigation (references)"},
+	"exposes":   {[]string{"to", "with"}, 0, exposurePattern, "@exposes Boundary:Component to Threat with Exposure (references)"},
+	"transfers": {[]string{"to", "with"}, 1, transferPattern, "@transfers Threat to Boundary:Component with Transfer (references)"},
+	"accepts":   {[]string{"to", "with"}, 1, acceptancePattern, "@accepts Threat to Boundary:Component with Acceptance (references)"},
+	"alias":     {[]string{"to"}, -1, aliasPattern, "@alias boundary|component|threat @id to Text [key: value; ...]"},
+}
+
+// editDistance returns the optimal string alignment distance between a and
+// b, counting insertions, deletions, substitutions and transpositions.
+func editDistance(a, b string) int {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(a)][len(b)]
+}
+
+// nearMiss returns the candidate closest to word when it is close enough to
+// be a likely typo. Short words must be within one edit.
+func nearMiss(word string, candidates []string) (string, bool) {
+	best, bestDistance := "", -1
+	for _, candidate := range candidates {
+		limit := 1
+		if len(candidate) >= 8 {
+			limit = 2
+		}
+		distance := editDistance(word, candidate)
+		if distance > 0 && distance <= limit && (bestDistance < 0 || distance < bestDistance) {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best, bestDistance > 0
+}
+
+func isTrigger(word string) bool {
+	for _, trigger := range triggerWords {
+		if strings.EqualFold(word, trigger) {
+			return true
+		}
+	}
+	return false
+}
+
+// findKeyword returns the index of the word keyword in words, starting at
+// from, or -1.
+func findKeyword(words []string, keyword string, from int) int {
+	for i := from; i < len(words); i++ {
+		if strings.EqualFold(words[i], keyword) {
+			return i
+		}
+	}
+	return -1
+}
+
+// balanceParentheses closes unclosed reference parentheses and drops
+// unmatched closing ones.
+func balanceParentheses(text string) string {
+	var balanced strings.Builder
+	depth := 0
+	for _, r := range text {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				continue
+			}
+			depth--
+		}
+		balanced.WriteRune(r)
+	}
+	return balanced.String() + strings.Repeat(")", depth)
+}
+
+// diagnose works out what is wrong with an annotation, returning a message
+// describing the problems and, when the problems can be fixed, a corrected
+// annotation. The trigger word may itself be a near miss.
+func diagnose(line string) (string, string, string) {
+	m := nearTriggerPattern.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", ""
+	}
+	word, body := m[2], m[3]
+	problems := make([]string, 0)
+
+	kind := strings.ToLower(word)
+	if !isTrigger(word) {
+		trigger, ok := nearMiss(word, triggerWords)
+		if !ok {
+			return "", "", ""
+		}
+		problems = append(problems, fmt.Sprintf("%q should be %q", "@"+word, "@"+trigger))
+		kind = trigger
+	}
+	form := annotationForms[kind]
+
+	words := strings.Fields(body)
+	positions := make([]int, 0, len(form.keywords))
+	from := 0
+	for _, keyword := range form.keywords {
+		position := findKeyword(words, keyword, from)
+		if position < 0 {
+			// Look for a misspelt keyword before giving up
+			for i := from; i < len(words); i++ {
+				if candidate, ok := nearMiss(words[i], []string{keyword}); ok && len(words[i]) > 2 {
+					problems = append(problems, fmt.Sprintf("%q should be %q", words[i], candidate))
+					words[i] = keyword
+					position = i
+					break
+				}
+			}
+		}
+		if position < 0 {
+			problems = append(problems, fmt.Sprintf("missing %q keyword", keyword))
+			break
+		}
+		positions = append(positions, position)
+		from = position + 1
+	}
+
+	// The boundary:component pair sits between the trigger or a keyword and the next keyword
+	if form.pair >= 0 && len(positions) > form.pair {
+		start := 0
+		if form.pair > 0 {
+			start = positions[form.pair-1] + 1
+		}
+		pair := words[start:positions[form.pair]]
+		if !strings.Contains(strings.Join(pair, " "), ":") {
+			problems = append(problems, "missing ':' between boundary and component")
+			if len(pair) == 2 {
+				words[start] = pair[0] + ":" + pair[1]
+				words = append(words[:start+1], words[start+2:]...)
+			}
+		}
+	}
+
+	// Bad threat attributes cannot be corrected automatically
+	fixable := true
+	if kind == "alias" && len(positions) > 0 {
+		if _, _, err := ParseThreatAttributes(strings.Join(words[positions[0]+1:], " ")); err != nil {
+			problems = append(problems, err.Error())
+			fixable = false
+		}
+	}
+
+	if strings.Count(body, "(") != strings.Count(body, ")") {
+		problems = append(problems, "unbalanced parentheses around references")
+	}
+
+	corrected := balanceParentheses(fmt.Sprintf("%s@%s %s", m[1], kind, strings.Join(words, " ")))
+
+	message := fmt.Sprintf("malformed @%s annotation, expected %s", kind, form.form)
+	if len(problems) > 0 {
+		message = fmt.Sprintf("malformed @%s annotation: %s", kind, strings.Join(problems, ", "))
+	}
+
+	suggestion := ""
+	if len(problems) > 0 && fixable && form.pattern.MatchString(corrected) {
+		suggestion = strings.TrimSpace(corrected)
+	}
+
+	return kind, message, suggestion
+}
+
+// nearMissDiagnostic reports a comment line whose trigger is a likely typo
+// of an annotation, such as @mitigate, which would otherwise be ignored.
+func nearMissDiagnostic(file string, line commentLine) *Diagnostic {
+	m := nearTriggerPattern.FindStringSubmatch(line.text)
+	if m == nil || isTrigger(m[2]) {
+		return nil
+	}
+
+	if kind, _, _ := diagnose(line.text); kind != "" {
+		return annotationDiagnostic(file, line, kind)
+	}
+	return nil
+}
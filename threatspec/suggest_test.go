@@ -0,0 +1,120 @@
+package threatspec
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	tests := []struct {
+		line       string
+		kind       string
+		problem    string
+		suggestion string
+	}{
+		{
+			"@mitigate WebApp:Web aginst abuse with validation",
+			"mitigates", `"@mitigate" should be "@mitigates", "aginst" should be "against"`,
+			"@mitigates WebApp:Web against abuse with validation",
+		},
+		{
+			"@exposes WebApp App to XSS with bad escaping",
+			"exposes", "missing ':' between boundary and component",
+			"@exposes WebApp:App to XSS with bad escaping",
+		},
+		{
+			"@exposes WebApp:App XSS with bad escaping",
+			"exposes", `missing "to" keyword`,
+			"",
+		},
+		{
+			"@transfers spoofing to User:Browser with tls (RFC 8446",
+			"transfers", "unbalanced parentheses around references",
+			"@transfers spoofing to User:Browser with tls (RFC 8446)",
+		},
+		{
+			"@acepts tampering to App:Db with audit logs",
+			"accepts", `"@acepts" should be "@accepts"`,
+			"@accepts tampering to App:Db with audit logs",
+		},
+		{
+			"@alias threat @xss to XSS [severity: extreme]",
+			"alias", `unknown rating "extreme"`,
+			"",
+		},
+		{"@param name the page name", "", "", ""},
+		{"@todo fix this", "", "", ""},
+	}
+
+	for _, test := range tests {
+		kind, message, suggestion := diagnose(test.line)
+		if kind != test.kind {
+			t.Errorf("diagnose(%q) kind = %q, want %q", test.line, kind, test.kind)
+			continue
+		}
+		if !strings.Contains(message, test.problem) {
+			t.Errorf("diagnose(%q) message = %q, want it to mention %q", test.line, message, test.problem)
+		}
+		if suggestion != test.suggestion {
+			t.Errorf("diagnose(%q) suggestion = %q, want %q", test.line, suggestion, test.suggestion)
+		}
+	}
+}
+
+func TestNearMissDiagnostics(t *testing.T) {
+	_, err := parseGo(t, `package example
+
+// @mitigate App:Web against abuse with validation
+// @params are not annotations
+func handler() {}
+`)
+	diagnostics, ok := err.(Diagnostics)
+	if !ok || len(diagnostics) != 1 {
+		t.Fatalf("ParseSourceFiles = %v, want one diagnostic", err)
+	}
+
+	d := diagnostics[0]
+	if d.File != "example.go" || d.Line != 3 || d.Column != 4 || d.Kind != "mitigates" {
+		t.Errorf("diagnostic at %s:%d:%d for %s, want example.go:3:4 for mitigates", d.File, d.Line, d.Column, d.Kind)
+	}
+	if d.Suggestion != "@mitigates App:Web against abuse with validation" {
+		t.Errorf("suggestion = %q", d.Suggestion)
+	}
+}
+
+func TestCatalogDiagnostics(t *testing.T) {
+	tests := []struct {
+		line       string
+		message    string
+		suggestion string
+	}{
+		{"@exposes WebApp:App to CWE-79 with raw output", "", ""},
+		{"@exposes WebApp:App to CWE-3522 with forged requests", "CWE-3522 is not in the CWE and CAPEC catalog", "@exposes WebApp:App to CWE-352 with forged requests"},
+		{"@transfers CAPEC-99999 to WebApp:App with a WAF", "CAPEC-99999 is not in the CWE and CAPEC catalog", ""},
+		{"@mitigates WebApp:App against XSS with escaping", "", ""},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{"example.threatspec": test.line + "\n"})
+		chdir(t, dir)
+
+		ts := New("wiki")
+		err := ts.ParseSpecFile("example.threatspec")
+		if test.message == "" {
+			if err != nil {
+				t.Errorf("ParseSpecFile(%q) = %v", test.line, err)
+			}
+			continue
+		}
+
+		diagnostics, ok := err.(Diagnostics)
+		if !ok || len(diagnostics) != 1 || diagnostics.HasErrors() {
+			t.Errorf("ParseSpecFile(%q) = %v, want one warning", test.line, err)
+			continue
+		}
+		if d := diagnostics[0]; !strings.Contains(d.Message, test.message) || d.Suggestion != test.suggestion || d.Column != strings.Index(test.line, "C")+1 {
+			t.Errorf("ParseSpecFile(%q) = %q at column %d, suggesting %q", test.line, d.Message, d.Column, d.Suggestion)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package broken
+
+import "example.com/wiki/store"
+
+// @exposes WebApp:Broken to information disclosure with an undefined page
+func Show() {
+	store.Load(undefinedTitle)
+}
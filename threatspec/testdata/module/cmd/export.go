@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+
+	"example.com/wiki/store"
+)
+
+// @exposes WebApp:Export to information disclosure with pages written to stdout
+func export(title string) {
+	page, _ := store.Load(title)
+	os.Stdout.Write(page)
+}
+
+func main() {
+	export(os.Args[1])
+}
@@ -0,0 +1,9 @@
+package main
+
+import "os"
+
+// A second program in the same directory, so the package as a whole does not
+// type check.
+func main() {
+	os.Exit(0)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	"example.com/wiki/store"
+)
+
+// @exposes WebApp:App to XSS injection with insufficient input validation
+func viewHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := store.Load(r.URL.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(page)
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "listen address")
+	flag.Parse()
+	http.HandleFunc("/view/", viewHandler)
+	http.ListenAndServe(*addr, nil)
+}
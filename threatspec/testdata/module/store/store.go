@@ -0,0 +1,8 @@
+package store
+
+import "os"
+
+// @mitigates WebApp:FileSystem against unauthorised access with strict file permissions
+func Load(title string) ([]byte, error) {
+	return os.ReadFile(title + ".txt")
+}
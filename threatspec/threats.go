@@ -0,0 +1,169 @@
+package threatspec
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// StrideCategories are the STRIDE categories a threat can fall under.
+var StrideCategories = []string{"spoofing", "tampering", "repudiation", "information_disclosure", "denial_of_service", "elevation_of_privilege"}
+
+// Ratings are the values accepted for a threat's severity and likelihood,
+// from lowest to highest.
+var Ratings = []string{"low", "medium", "high", "critical"}
+
+var threatAttributesPattern = regexp.MustCompile(`^(?P<text>.*?)\s*\[(?P<attributes>[^\[\]]*)\]\s*$`)
+var catalogIdPattern = regexp.MustCompile(`(?i)^(?:(?P<prefix>[a-z]+)[-_ ]?)?(?P<number>[0-9]+)$`)
+
+// NormaliseStride returns the STRIDE category named by category, which may be
+// the full name in any case, with spaces or underscores, or its initial.
+func NormaliseStride(category string) (string, error) {
+	clean := strings.ToLower(strings.Join(strings.Fields(strings.Replace(category, "_", " ", -1)), "_"))
+	for _, stride := range StrideCategories {
+		if clean == stride || (len(clean) == 1 && stride[:1] == clean) {
+			return stride, nil
+		}
+	}
+	return "", fmt.Errorf("unknown STRIDE category %q", category)
+}
+
+// NormaliseRating returns rating in lower case if it is one of Ratings.
+func NormaliseRating(rating string) (string, error) {
+	clean := strings.ToLower(strings.TrimSpace(rating))
+	for _, r := range Ratings {
+		if clean == r {
+			return r, nil
+		}
+	}
+	return "", fmt.Errorf("unknown rating %q, expected %s", rating, strings.Join(Ratings, ", "))
+}
+
+// normaliseCatalogId turns 79, cwe79 or cwe-79 into CWE-79 for the prefix CWE.
+func normaliseCatalogId(prefix string, id string) (string, error) {
+	m := catalogIdPattern.FindStringSubmatch(strings.TrimSpace(id))
+	if m == nil || (m[1] != "" && !strings.EqualFold(m[1], prefix)) {
+		return "", fmt.Errorf("invalid %s id %q", prefix, id)
+	}
+	return prefix + "-" + m[2], nil
+}
+
+func splitValues(values string) []string {
+	list := make([]string, 0)
+	for _, value := range strings.Split(values, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// ParseThreatAttributes splits the trailing attribute block off an @alias
+// threat text, as in
+//
+//	Cross-site scripting [stride: tampering; cwe: 79; capec: 63; owasp: A03:2021; severity: high; likelihood: medium]
+//
+// returning the remaining text and a Threat holding the attributes. Lists
+// are comma separated. Text without an attribute block returns a nil Threat.
+func ParseThreatAttributes(text string) (string, *Threat, error) {
+	m := threatAttributesPattern.FindStringSubmatch(text)
+	if m == nil {
+		return text, nil, nil
+	}
+
+	threat := &Threat{Name: m[1]}
+	for _, attribute := range strings.Split(m[2], ";") {
+		if strings.TrimSpace(attribute) == "" {
+			continue
+		}
+		parts := strings.SplitN(attribute, ":", 2)
+		if len(parts) != 2 {
+			return "", nil, fmt.Errorf("threat attribute %q should be written as key: value", strings.TrimSpace(attribute))
+		}
+		key, value := strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+
+		switch key {
+		case "stride":
+			for _, category := range splitValues(value) {
+				stride, err := NormaliseStride(category)
+				if err != nil {
+					return "", nil, err
+				}
+				threat.Stride = append(threat.Stride, stride)
+			}
+		case "cwe", "capec":
+			for _, id := range splitValues(value) {
+				normalised, err := normaliseCatalogId(strings.ToUpper(key), id)
+				if err != nil {
+					return "", nil, err
+				}
+				if key == "cwe" {
+					threat.Cwe = append(threat.Cwe, normalised)
+				} else {
+					threat.Capec = append(threat.Capec, normalised)
+				}
+			}
+		case "owasp":
+			threat.Owasp = value
+		case "severity", "likelihood":
+			rating, err := NormaliseRating(value)
+			if err != nil {
+				return "", nil, err
+			}
+			if key == "severity" {
+				threat.Severity = rating
+			} else {
+				threat.Likelihood = rating
+			}
+		case "description":
+			threat.Description = value
+		case "references":
+			threat.References = splitValues(value)
+		default:
+			return "", nil, fmt.Errorf("unknown threat attribute %q", key)
+		}
+	}
+
+	return m[1], threat, nil
+}
+
+func appendMissing(list []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range list {
+			if existing == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// Describe fills in the optional fields of t from details. Lists are
+// extended and single values replaced when details sets them.
+func (t *Threat) Describe(details *Threat) {
+	if details == nil {
+		return
+	}
+
+	if details.Description != "" {
+		t.Description = details.Description
+	}
+	t.References = appendMissing(t.References, details.References...)
+	t.Stride = appendMissing(t.Stride, details.Stride...)
+	t.Cwe = appendMissing(t.Cwe, details.Cwe...)
+	t.Capec = appendMissing(t.Capec, details.Capec...)
+	if details.Owasp != "" {
+		t.Owasp = details.Owasp
+	}
+	if details.Severity != "" {
+		t.Severity = details.Severity
+	}
+	if details.Likelihood != "" {
+		t.Likelihood = details.Likelihood
+	}
+}
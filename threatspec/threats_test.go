@@ -0,0 +1,65 @@
+package threatspec
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadThreatLibrary(t *testing.T) {
+	ts := New("Simple")
+	if err := ts.Parse([]string{filepath.Join("..", "stride.json")}); err != nil {
+		t.Fatalf("Parse(stride.json): %v", err)
+	}
+
+	threat := ts.Threats["@cats_like_milk"]
+	if threat == nil {
+		t.Fatal("threat @cats_like_milk not loaded")
+	}
+	if !reflect.DeepEqual(threat.Stride, []string{"denial_of_service"}) || threat.Severity != "low" || threat.Likelihood != "high" {
+		t.Errorf("stride.json threat = %+v, want stride, severity and likelihood set", threat)
+	}
+	if err := ts.Validate(); err != nil {
+		t.Errorf("spec with stride.json is not valid: %v", err)
+	}
+}
+
+func TestParseThreatAttributes(t *testing.T) {
+	tests := []struct {
+		text   string
+		name   string
+		threat *Threat
+		err    bool
+	}{
+		{"Plain threat", "Plain threat", nil, false},
+		{
+			"Cross-site scripting [stride: t, I; cwe: 79; capec: capec-63; owasp: A03:2021; severity: High; likelihood: medium]",
+			"Cross-site scripting",
+			&Threat{
+				Name:       "Cross-site scripting",
+				Stride:     []string{"tampering", "information_disclosure"},
+				Cwe:        []string{"CWE-79"},
+				Capec:      []string{"CAPEC-63"},
+				Owasp:      "A03:2021",
+				Severity:   "high",
+				Likelihood: "medium",
+			},
+			false,
+		},
+		{"Bad [stride: sneaking]", "", nil, true},
+		{"Bad [cwe: capec-63]", "", nil, true},
+		{"Bad [colour: red]", "", nil, true},
+		{"Bad [severity]", "", nil, true},
+	}
+
+	for _, test := range tests {
+		name, threat, err := ParseThreatAttributes(test.text)
+		if (err != nil) != test.err {
+			t.Errorf("ParseThreatAttributes(%q) error = %v", test.text, err)
+			continue
+		}
+		if name != test.name || !reflect.DeepEqual(threat, test.threat) {
+			t.Errorf("ParseThreatAttributes(%q) = %q, %+v, want %q, %+v", test.text, name, threat, test.name, test.threat)
+		}
+	}
+}
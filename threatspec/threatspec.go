@@ -6,10 +6,11 @@ import (
 	"fmt"
 	"github.com/xeipuuv/gojsonschema"
 	"go/ast"
-	"go/parser"
 	"go/token"
+	"go/types"
 	"io/ioutil"
 	"path"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
@@ -35,8 +36,6 @@ var transferPattern = regexp.MustCompile(`(?i)^\s*@transfers (?P<threat>.+?) to
 
 type Id string
 
-var ProjectName string
-
 type Document struct {
 	Created int64 `json:"created"`
 	Updated int64 `json:"updated"`
@@ -48,8 +47,9 @@ type Specification struct {
 }
 
 type Alias struct {
-	Class string `json:"string"`
-	Text  string `json:"text"`
+	Class  string  `json:"string"`
+	Text   string  `json:"text"`
+	Threat *Threat `json:"threat,omitempty"` // attributes given to a threat alias
 }
 
 type Boundary struct {
@@ -62,16 +62,33 @@ type Component struct {
 	Description string `json:"description,omitempty"`
 }
 
+// Threat fields other than Name are optional. Stride holds the STRIDE
+// categories the threat falls under, Cwe and Capec identifiers such as
+// CWE-79 and CAPEC-63, Owasp an OWASP Top 10 category such as A03:2021, and
+// Severity and Likelihood its default rating, one of Ratings.
 type Threat struct {
 	Name        string   `json:"name"`
 	Description string   `json:"description,omitempty"`
 	References  []string `json:"references,omitempty"`
+	Stride      []string `json:"stride,omitempty"`
+	Cwe         []string `json:"cwe,omitempty"`
+	Capec       []string `json:"capec,omitempty"`
+	Owasp       string   `json:"owasp,omitempty"`
+	Severity    string   `json:"severity,omitempty"`
+	Likelihood  string   `json:"likelihood,omitempty"`
 }
 
+// Source records where an annotation was found. Kind is the kind of
+// declaration carrying it: function, type, field, var, const or package.
+// For declarations other than functions, Function holds the declaration's
+// qualified name. Annotations inside a function body record their own line
+// and column, while those in doc comments record the declaration's line.
 type Source struct {
 	Function string `json:"function"`
+	Kind     string `json:"kind,omitempty"`
 	File     string `json:"file"`
 	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
 }
 
 type Mitigation struct {
@@ -115,11 +132,14 @@ type Call struct {
 	Destination string `json:"destination"`
 }
 
+// Project holds the annotations of one project. A document may hold several
+// projects, keyed by name in ThreatSpec.Projects.
 type Project struct {
 	Mitigations map[Id][]*Mitigation `json:"mitigations"`
 	Exposures   map[Id][]*Exposure   `json:"exposures"`
 	Transfers   map[Id][]*Transfer   `json:"transfers"`
 	Acceptances map[Id][]*Acceptance `json:"acceptances"`
+	Risks       []*Risk              `json:"risks,omitempty"`
 }
 
 type ThreatSpec struct {
@@ -130,6 +150,9 @@ type ThreatSpec struct {
 	Threats       map[Id]*Threat      `json:"threats"`
 	Projects      map[string]*Project `json:"projects"`
 	CallFlow      []*Call             `json:"callflow,omitempty"`
+	Scoring       string              `json:"scoring,omitempty"` // name of the Scorer behind Project.Risks
+	Project       string              `json:"-"`                 // project for annotations not assigned by Options.Projects
+	Options       Options             `json:"-"`
 }
 
 /* ****************************************************************
@@ -138,7 +161,8 @@ type ThreatSpec struct {
 
 type Function struct {
 	Name     string
-	Package  string
+	Parent   *Function // enclosing function of a closure
+	Package  string    // full import path, or the package name outside GOPATH and modules
 	Type     string
 	Begin    int
 	End      int
@@ -146,8 +170,61 @@ type Function struct {
 	Comments []*ast.CommentGroup
 }
 
+// ReceiverName renders a method receiver type as used in Function.FullName,
+// for example "Page", "(*Page)" or "(*Set[K,V])" for generic types.
+// Parentheses around the receiver type are dropped.
+func ReceiverName(recv ast.Expr) string {
+	switch x := recv.(type) {
+	case *ast.ParenExpr:
+		return ReceiverName(x.X)
+	case *ast.StarExpr:
+		return "(*" + receiverTypeName(x.X) + ")"
+	default:
+		return receiverTypeName(x)
+	}
+}
+
+// TypeSpecName renders a type declaration's name the same way ReceiverName
+// renders a receiver, including any type parameters.
+func TypeSpecName(spec *ast.TypeSpec) string {
+	if spec.TypeParams == nil || len(spec.TypeParams.List) == 0 {
+		return spec.Name.Name
+	}
+
+	params := make([]string, 0)
+	for _, field := range spec.TypeParams.List {
+		for _, name := range field.Names {
+			params = append(params, name.Name)
+		}
+	}
+	return spec.Name.Name + "[" + strings.Join(params, ",") + "]"
+}
+
+func receiverTypeName(expr ast.Expr) string {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		return x.Name
+	case *ast.ParenExpr:
+		return receiverTypeName(x.X)
+	case *ast.SelectorExpr:
+		return x.Sel.Name
+	case *ast.IndexExpr:
+		return receiverTypeName(x.X) + "[" + types.ExprString(x.Index) + "]"
+	case *ast.IndexListExpr:
+		params := make([]string, 0, len(x.Indices))
+		for _, index := range x.Indices {
+			params = append(params, types.ExprString(index))
+		}
+		return receiverTypeName(x.X) + "[" + strings.Join(params, ",") + "]"
+	default:
+		return types.ExprString(expr)
+	}
+}
+
 func (f *Function) FullName() string {
-	if f.Type != "" {
+	if f.Parent != nil {
+		return fmt.Sprintf("%s.%s", f.Parent.FullName(), f.Name)
+	} else if f.Type != "" {
 		return fmt.Sprintf("%s.%s.%s", f.Package, f.Type, f.Name)
 	} else {
 		return fmt.Sprintf("%s.%s", f.Package, f.Name)
@@ -171,6 +248,7 @@ func (f *Function) LocationStartString() string {
 func (f *Function) ToSource() *Source {
 	return &Source{
 		Function: f.FullName(),
+		Kind:     "function",
 		File:     f.Filepath,
 		Line:     f.Line(),
 	}
@@ -180,10 +258,11 @@ func (f *Function) ToSource() *Source {
  * Main functions
  * ****************************************************************/
 
+// New returns an empty spec whose annotations go to project unless
+// Options.Projects assigns them elsewhere. Projects are only added to the
+// document once they hold something.
 func New(project string) *ThreatSpec {
-	ProjectName = project
-
-	ts := &ThreatSpec{
+	return &ThreatSpec{
 		Specification: &Specification{
 			Name:    SpecName,
 			Version: SpecVersion,
@@ -197,15 +276,8 @@ func New(project string) *ThreatSpec {
 		Threats:    make(map[Id]*Threat),
 		Projects:   make(map[string]*Project),
 		CallFlow:   make([]*Call, 0),
+		Project:    project,
 	}
-	ts.Projects[ProjectName] = &Project{
-		Mitigations: make(map[Id][]*Mitigation),
-		Exposures:   make(map[Id][]*Exposure),
-		Transfers:   make(map[Id][]*Transfer),
-		Acceptances: make(map[Id][]*Acceptance),
-	}
-
-	return ts
 }
 
 func (ts *ThreatSpec) ToJson() string {
@@ -217,7 +289,17 @@ func (ts *ThreatSpec) ToJson() string {
 }
 
 func (ts *ThreatSpec) ValidateJson(jsonBlob string) error {
-	schemaLoader := gojsonschema.NewStringLoader(ThreatSpecSchemaStrictv0)
+	return validateJson(ThreatSpecSchemaStrictv0, jsonBlob)
+}
+
+// ValidateLibraryJson checks a partial document, such as a threat library,
+// which only needs to hold the sections it uses.
+func (ts *ThreatSpec) ValidateLibraryJson(jsonBlob string) error {
+	return validateJson(ThreatSpecSchemaLibraryv0, jsonBlob)
+}
+
+func validateJson(schema string, jsonBlob string) error {
+	schemaLoader := gojsonschema.NewStringLoader(schema)
 	documentLoader := gojsonschema.NewStringLoader(jsonBlob)
 
 	if result, err := gojsonschema.Validate(schemaLoader, documentLoader); err != nil {
@@ -292,10 +374,21 @@ func (ts *ThreatSpec) ParseAlias(line string) (Id, *Alias) {
 	}
 	aliasId := ts.ToId(m["alias"])
 
-	return aliasId, &Alias{
+	alias := &Alias{
 		Class: m["class"],
 		Text:  m["text"],
 	}
+
+	// Threats may carry a trailing [key: value; ...] attribute block
+	if strings.EqualFold(alias.Class, "threat") {
+		text, threat, err := ParseThreatAttributes(alias.Text)
+		if err != nil {
+			return "", nil
+		}
+		alias.Text, alias.Threat = text, threat
+	}
+
+	return aliasId, alias
 }
 
 func (ts *ThreatSpec) ParseMitigation(line string, source *Source) (Id, *Mitigation) {
@@ -414,8 +507,18 @@ func (ts *ThreatSpec) AddComponent(id Id, component string) Id {
 	return id
 }
 
+// AddThreat adds a threat unless one with the same id exists. Threats named
+// by a catalog reference, such as CWE-79 or @cwe_79, are resolved to the
+// catalog's name, description and reference.
 func (ts *ThreatSpec) AddThreat(id Id, threat string) Id {
 	if id == "" {
+		if entry := LookupCatalog(threat); entry != nil {
+			id = entry.ThreatId()
+			if _, ok := ts.Threats[id]; !ok {
+				ts.Threats[id] = entry.ToThreat()
+			}
+			return id
+		}
 		id = ts.ToId(threat)
 	}
 
@@ -433,45 +536,93 @@ func (ts *ThreatSpec) AddAlias(id Id, alias *Alias) {
 	case "component":
 		ts.AddComponent(id, alias.Text)
 	case "threat":
-		ts.AddThreat(id, alias.Text)
+		id = ts.AddThreat(id, alias.Text)
+		ts.Threats[id].Describe(alias.Threat)
 	}
 }
 
+// BoundaryName returns the name of boundary id, or the id itself when the
+// boundary is not defined.
+func (ts *ThreatSpec) BoundaryName(id Id) string {
+	if boundary := ts.Boundaries[id]; boundary != nil {
+		return boundary.Name
+	}
+	return string(id)
+}
+
+// ComponentName returns the name of component id, or the id itself when the
+// component is not defined.
+func (ts *ThreatSpec) ComponentName(id Id) string {
+	if component := ts.Components[id]; component != nil {
+		return component.Name
+	}
+	return string(id)
+}
+
+// ThreatName returns the name of threat id, or the id itself when the threat
+// is not defined.
+func (ts *ThreatSpec) ThreatName(id Id) string {
+	if threat := ts.Threats[id]; threat != nil {
+		return threat.Name
+	}
+	return string(id)
+}
+
+// AddMitigation adds a mitigation to the default project, ts.Project. Use
+// AddProject to add to another project.
 func (ts *ThreatSpec) AddMitigation(id Id, mitigation *Mitigation) {
-	ts.Projects[ProjectName].Mitigations[id] = append(ts.Projects[ProjectName].Mitigations[id], mitigation)
+	ts.AddProject(ts.Project).AddMitigation(id, mitigation)
 }
 
 func (ts *ThreatSpec) AddExposure(id Id, exposure *Exposure) {
-	ts.Projects[ProjectName].Exposures[id] = append(ts.Projects[ProjectName].Exposures[id], exposure)
+	ts.AddProject(ts.Project).AddExposure(id, exposure)
 }
 
 func (ts *ThreatSpec) AddTransfer(id Id, transfer *Transfer) {
-	ts.Projects[ProjectName].Transfers[id] = append(ts.Projects[ProjectName].Transfers[id], transfer)
+	ts.AddProject(ts.Project).AddTransfer(id, transfer)
 }
 
 func (ts *ThreatSpec) AddAcceptance(id Id, acceptance *Acceptance) {
-	ts.Projects[ProjectName].Acceptances[id] = append(ts.Projects[ProjectName].Acceptances[id], acceptance)
+	ts.AddProject(ts.Project).AddAcceptance(id, acceptance)
 }
 
-func (ts *ThreatSpec) Parse(filenames []string) error {
+// Parse reads annotations from the given files, directories and package
+// patterns. Problems in one input do not stop the others from being parsed;
+// they are all returned together as Diagnostics.
+func (ts *ThreatSpec) Parse(paths []string) error {
+	filenames, err := ts.ExpandPaths(paths)
+	if err != nil {
+		return err
+	}
+
+	// Go files are loaded together so that packages are resolved once
+	sourceFiles := make([]string, 0)
+	diagnostics := make(Diagnostics, 0)
 
 	for _, filename := range filenames {
 		switch path.Ext(filename) {
 		case ".go":
-			if err := ts.ParseSourceFile(filename); err != nil {
-				return err
-			}
+			sourceFiles = append(sourceFiles, filename)
 		case ".threatspec":
 			if err := ts.ParseSpecFile(filename); err != nil {
-				return err
+				diagnostics = append(diagnostics, ToDiagnostics(filename, err)...)
 			}
 		case ".json":
 			if err := ts.LoadFile(filename); err != nil {
-				return err
+				diagnostics = append(diagnostics, ToDiagnostics(filename, err)...)
 			}
 		}
 	}
 
+	if len(sourceFiles) > 0 {
+		if err := ts.ParseSourceFiles(sourceFiles); err != nil {
+			diagnostics = append(diagnostics, ToDiagnostics("", err)...)
+		}
+	}
+
+	if len(diagnostics) > 0 {
+		return diagnostics
+	}
 	return nil
 }
 
@@ -481,12 +632,24 @@ func (ts *ThreatSpec) ParseSpecFile(filename string) error {
 		return err
 	}
 
-	failedMatches := make([]string, 0)
+	diagnostics := make(Diagnostics, 0)
+	project := ts.ProjectFor(filename)
 
-	for _, line := range strings.Split(string(content), "\n") {
+	lines := make([]commentLine, 0)
+	for i, line := range strings.Split(string(content), "\n") {
+		lines = append(lines, commentLine{line, i + 1, 1})
+	}
+
+	for _, joined := range joinContinuations(lines) {
+		line := joined.text
+		// Annotations point at the spec file, without a function
+		source := &Source{File: filename, Line: joined.line}
 
 		matchType, matches := ts.ParseTrigger(line)
 		if !matches {
+			if diagnostic := nearMissDiagnostic(filename, joined); diagnostic != nil {
+				diagnostics = append(diagnostics, diagnostic)
+			}
 			continue
 		}
 
@@ -495,60 +658,372 @@ func (ts *ThreatSpec) ParseSpecFile(filename string) error {
 			if id, alias := ts.ParseAlias(line); alias != nil {
 				ts.AddAlias(id, alias)
 			} else {
-				failedMatches = append(failedMatches, line)
+				diagnostics = append(diagnostics, annotationDiagnostic(filename, joined, matchType))
 			}
 		case "mitigates":
-			if id, mitigation := ts.ParseMitigation(line, nil); mitigation != nil {
-				ts.AddMitigation(id, mitigation)
+			if id, mitigation := ts.ParseMitigation(line, source); mitigation != nil {
+				ts.AddProject(project).AddMitigation(id, mitigation)
 			} else {
-				failedMatches = append(failedMatches, line)
+				diagnostics = append(diagnostics, annotationDiagnostic(filename, joined, matchType))
 			}
 		case "exposes":
-			if id, exposure := ts.ParseExposure(line, nil); exposure != nil {
-				ts.AddExposure(id, exposure)
+			if id, exposure := ts.ParseExposure(line, source); exposure != nil {
+				ts.AddProject(project).AddExposure(id, exposure)
 			} else {
-				failedMatches = append(failedMatches, line)
+				diagnostics = append(diagnostics, annotationDiagnostic(filename, joined, matchType))
 			}
 		case "transfers":
-			if id, transfer := ts.ParseTransfer(line, nil); transfer != nil {
-				ts.AddTransfer(id, transfer)
+			if id, transfer := ts.ParseTransfer(line, source); transfer != nil {
+				ts.AddProject(project).AddTransfer(id, transfer)
 			} else {
-				failedMatches = append(failedMatches, line)
+				diagnostics = append(diagnostics, annotationDiagnostic(filename, joined, matchType))
 			}
 		case "accepts":
-			if id, acceptance := ts.ParseAcceptance(line, nil); acceptance != nil {
-				ts.AddAcceptance(id, acceptance)
+			if id, acceptance := ts.ParseAcceptance(line, source); acceptance != nil {
+				ts.AddProject(project).AddAcceptance(id, acceptance)
 			} else {
-				failedMatches = append(failedMatches, line)
+				diagnostics = append(diagnostics, annotationDiagnostic(filename, joined, matchType))
 			}
 		}
 
+		if diagnostic := ts.catalogDiagnostic(filename, joined, matchType); diagnostic != nil {
+			diagnostics = append(diagnostics, diagnostic)
+		}
 	}
 
-	if len(failedMatches) > 0 {
-		return fmt.Errorf("failed to parse lines:\n - %s\n", strings.Join(failedMatches, "\n - "))
+	if len(diagnostics) > 0 {
+		return diagnostics
 	}
 
 	return nil
 }
 
 func (ts *ThreatSpec) ParseSourceFile(filename string) error {
-	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
-	if err != nil {
+	return ts.ParseSourceFiles([]string{filename})
+}
+
+func (ts *ThreatSpec) ParseSourceFiles(filenames []string) error {
+	// Syntax errors are reported, but the remaining files are still parsed
+	diagnostics := make(Diagnostics, 0)
+	pkgs, err := ts.LoadPackages(filenames)
+	if loadDiagnostics, ok := err.(Diagnostics); ok {
+		diagnostics = append(diagnostics, loadDiagnostics...)
+	} else if err != nil {
 		return err
 	}
 
+	wanted := make(map[string]bool)
+	for _, filename := range filenames {
+		if abs, err := filepath.Abs(filename); err == nil {
+			wanted[abs] = true
+		}
+	}
+
+	// Test variants of a package repeat its files, so only parse each once
+	seen := make(map[string]bool)
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Syntax {
+			filename := pkg.Fset.Position(f.Pos()).Filename
+			if !wanted[filename] || seen[filename] {
+				continue
+			}
+			seen[filename] = true
+			diagnostics = append(diagnostics, ts.parseAstFile(pkg.Fset, pkg.PkgPath, f)...)
+		}
+	}
+
+	if ts.Options.CallFlow {
+		diagnostics = append(diagnostics, ts.BuildCallFlow(pkgs)...)
+	}
+
+	if len(diagnostics) > 0 {
+		return diagnostics
+	}
+	return nil
+}
+
+// closureComments returns the comment group ending on the line before a
+// function literal, such as one above a return statement or a call
+// registering an anonymous handler. A group that documents an enclosing
+// declaration belongs to that declaration instead.
+func closureComments(fset *token.FileSet, f *ast.File, lit *ast.FuncLit, parents []ast.Node) []*ast.CommentGroup {
+	line := fset.Position(lit.Pos()).Line
+
+	for _, group := range f.Comments {
+		if fset.Position(group.End()).Line != line-1 {
+			continue
+		}
+		for _, parent := range parents {
+			switch decl := parent.(type) {
+			case *ast.FuncDecl:
+				if decl.Doc == group {
+					return nil
+				}
+			case *ast.GenDecl:
+				if decl.Doc == group {
+					return nil
+				}
+			case *ast.ValueSpec:
+				if decl.Doc == group {
+					return nil
+				}
+			}
+		}
+		return []*ast.CommentGroup{group}
+	}
+
+	return nil
+}
+
+func specNames(spec ast.Spec) []string {
+	switch x := spec.(type) {
+	case *ast.TypeSpec:
+		return []string{TypeSpecName(x)}
+	case *ast.ValueSpec:
+		names := make([]string, 0, len(x.Names))
+		for _, name := range x.Names {
+			names = append(names, name.Name)
+		}
+		return names
+	}
+	return nil
+}
+
+func fieldName(field *ast.Field) string {
+	if len(field.Names) == 0 {
+		// Embedded fields are named after their type
+		return receiverTypeName(field.Type)
+	}
+	names := make([]string, 0, len(field.Names))
+	for _, name := range field.Names {
+		names = append(names, name.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// fieldOwner returns the qualified name of the struct field whose parents
+// are given, or "" for fields that are not part of a named struct, such as
+// function parameters.
+func fieldOwner(field *ast.Field, parents []ast.Node) string {
+	name := fieldName(field)
+
+	for i := len(parents) - 1; i >= 0; i-- {
+		switch x := parents[i].(type) {
+		case *ast.FieldList:
+			if i == 0 {
+				return ""
+			}
+			if _, ok := parents[i-1].(*ast.StructType); !ok {
+				return ""
+			}
+		case *ast.StructType:
+		case *ast.StarExpr, *ast.ArrayType, *ast.MapType:
+		case *ast.Field:
+			name = fieldName(x) + "." + name
+		case *ast.TypeSpec, *ast.ValueSpec:
+			return strings.Join(specNames(x.(ast.Spec)), ",") + "." + name
+		default:
+			return ""
+		}
+	}
+
+	return ""
+}
+
+func declKind(decl *ast.GenDecl) string {
+	switch decl.Tok {
+	case token.TYPE:
+		return "type"
+	case token.VAR:
+		return "var"
+	case token.CONST:
+		return "const"
+	}
+	return ""
+}
+
+func declSource(fset *token.FileSet, pos token.Pos, name string, kind string) *Source {
+	return &Source{
+		Function: name,
+		Kind:     kind,
+		File:     displayPath(fset.Position(pos).Filename),
+		Line:     fset.Position(pos).Line,
+	}
+}
+
+// parseFunctionComments adds the annotations found in a function's comments
+// to project, returning diagnostics for those that could not be parsed.
+func (ts *ThreatSpec) parseFunctionComments(fset *token.FileSet, project string, function *Function) Diagnostics {
+	return ts.parseComments(fset, project, function.Comments, function.ToSource())
+}
+
+// parseComments adds the annotations found in comments attributed to source
+// to project, returning diagnostics for those that could not be parsed.
+func (ts *ThreatSpec) parseComments(fset *token.FileSet, project string, comments []*ast.CommentGroup, source *Source) Diagnostics {
+	diagnostics := make(Diagnostics, 0)
+
+	for _, lines := range comments {
+		file := displayPath(fset.Position(lines.Pos()).Filename)
+		for _, line := range joinContinuations(commentLines(fset, lines)) {
+			if kind, ok := ts.parseAnnotation(project, line.text, source); !ok {
+				diagnostics = append(diagnostics, annotationDiagnostic(file, line, kind))
+			} else if diagnostic := ts.catalogDiagnostic(file, line, kind); diagnostic != nil {
+				diagnostics = append(diagnostics, diagnostic)
+			}
+		}
+	}
+
+	return diagnostics
+}
+
+// parseAnnotation adds the mitigation, exposure, transfer or acceptance on
+// line, if any, to project. It returns the annotation kind, and false if line
+// has a trigger but cannot be parsed.
+func (ts *ThreatSpec) parseAnnotation(project string, line string, source *Source) (string, bool) {
+	matchType, matches := ts.ParseTrigger(line)
+	if !matches {
+		return "", true
+	}
+
+	switch matchType {
+	case "mitigates":
+		if id, mitigation := ts.ParseMitigation(line, source); mitigation != nil {
+			ts.AddProject(project).AddMitigation(id, mitigation)
+		} else {
+			return matchType, false
+		}
+	case "exposes":
+		if id, exposure := ts.ParseExposure(line, source); exposure != nil {
+			ts.AddProject(project).AddExposure(id, exposure)
+		} else {
+			return matchType, false
+		}
+	case "transfers":
+		if id, transfer := ts.ParseTransfer(line, source); transfer != nil {
+			ts.AddProject(project).AddTransfer(id, transfer)
+		} else {
+			return matchType, false
+		}
+	case "accepts":
+		if id, acceptance := ts.ParseAcceptance(line, source); acceptance != nil {
+			ts.AddProject(project).AddAcceptance(id, acceptance)
+		} else {
+			return matchType, false
+		}
+	}
+
+	return matchType, true
+}
+
+type commentLine struct {
+	text   string
+	line   int
+	column int
+}
+
+// commentLines splits a comment group into lines of text, keeping the
+// position at which each line's text starts.
+func commentLines(fset *token.FileSet, group *ast.CommentGroup) []commentLine {
+	lines := make([]commentLine, 0)
+
+	for _, comment := range group.List {
+		pos := fset.Position(comment.Slash)
+		if strings.HasPrefix(comment.Text, "//") {
+			lines = append(lines, commentLine{comment.Text[2:], pos.Line, pos.Column + 2})
+			continue
+		}
+
+		text := strings.TrimSuffix(strings.TrimPrefix(comment.Text, "/*"), "*/")
+		for i, line := range strings.Split(text, "\n") {
+			column := 1
+			if i == 0 {
+				column = pos.Column + 2
+			}
+			lines = append(lines, commentLine{line, pos.Line + i, column})
+		}
+	}
+
+	return lines
+}
+
+func indentation(text string) int {
+	return len(text) - len(strings.TrimLeft(text, " \t"))
+}
+
+// joinContinuations folds continuation lines into the annotation they
+// continue, so that one annotation can span several lines. A line continues
+// an annotation when the previous line ends in a backslash, or when it is
+// indented further than the line starting the annotation. A blank line
+// always ends an annotation. Joined annotations keep the position of their
+// first line.
+func joinContinuations(lines []commentLine) []commentLine {
+	joined := make([]commentLine, 0, len(lines))
+	open := false
+	backslash := false
+	indent := 0
+
+	for _, line := range lines {
+		text := strings.TrimRight(line.text, " \t\r")
+		continued := strings.HasSuffix(text, "\\")
+		text = strings.TrimRight(strings.TrimSuffix(text, "\\"), " \t")
+
+		if open && strings.TrimSpace(text) != "" &&
+			(backslash || (indentation(text) > indent && !triggerPattern.MatchString(text))) {
+			last := &joined[len(joined)-1]
+			last.text += " " + strings.TrimSpace(text)
+			backslash = continued
+			continue
+		}
+
+		open = triggerPattern.MatchString(text)
+		if open {
+			line.text = text
+			indent = indentation(text)
+			backslash = continued
+		}
+		joined = append(joined, line)
+	}
+
+	return joined
+}
+
+// parseInlineComments adds the annotations found in comments inside a
+// function body. Each annotation's source points at its own line and column
+// rather than at the start of the function.
+func (ts *ThreatSpec) parseInlineComments(fset *token.FileSet, project string, function *Function, group *ast.CommentGroup) Diagnostics {
+	diagnostics := make(Diagnostics, 0)
+
+	for _, line := range joinContinuations(commentLines(fset, group)) {
+		source := function.ToSource()
+		source.Line = line.line
+		source.Column = line.column + strings.Index(line.text, "@")
+
+		if kind, ok := ts.parseAnnotation(project, line.text, source); !ok {
+			diagnostics = append(diagnostics, annotationDiagnostic(source.File, line, kind))
+		}
+	}
+
+	return diagnostics
+}
+
+func (ts *ThreatSpec) parseAstFile(fset *token.FileSet, pkgPath string, f *ast.File) Diagnostics {
 	cmap := ast.NewCommentMap(fset, f, f.Comments)
+	project := ts.ProjectFor(fset.Position(f.Pos()).Filename)
 
-	failedMatches := make([]string, 0)
+	diagnostics := make(Diagnostics, 0)
 
 	// Iterate all looking for non-function comments
 	for _, lines := range cmap.Comments() {
-		for _, line := range strings.Split(lines.Text(), "\n") {
+		file := displayPath(fset.Position(lines.Pos()).Filename)
+		for _, joined := range joinContinuations(commentLines(fset, lines)) {
+			line := strings.TrimSpace(joined.text)
 
+			// Near misses are only reported here, as every comment is visited once
 			matchType, matches := ts.ParseTrigger(line)
 			if !matches {
+				if diagnostic := nearMissDiagnostic(file, joined); diagnostic != nil {
+					diagnostics = append(diagnostics, diagnostic)
+				}
 				continue
 			}
 
@@ -557,107 +1032,251 @@ func (ts *ThreatSpec) ParseSourceFile(filename string) error {
 				if id, alias := ts.ParseAlias(line); alias != nil {
 					ts.AddAlias(id, alias)
 				} else {
-					failedMatches = append(failedMatches, line)
+					diagnostics = append(diagnostics, annotationDiagnostic(file, joined, matchType))
 				}
 			}
 		}
 	}
 
-	// Look for function-specific comments
+	// Look for function-specific comments, keeping track of the enclosing
+	// nodes so that closures can be named after the function creating them
+	stack := make([]ast.Node, 0)
+	functions := make(map[ast.Node]*Function)
+	closures := make(map[*Function]int)
+	initFunction := &Function{Package: pkgPath, Name: "init"}
+	bodies := make(map[*Function]*ast.BlockStmt)
+	consumed := make(map[*ast.CommentGroup]bool)
+
 	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		parents := stack
+		stack = append(stack, n)
+
+		// Declarations inside function bodies belong to the function
+		inFunction := false
+		for _, parent := range parents {
+			if _, ok := functions[parent]; ok {
+				inFunction = true
+				break
+			}
+		}
+
 		switch x := n.(type) {
+		case *ast.File:
+			if groups := cmap[n]; len(groups) > 0 {
+				source := declSource(fset, x.Package, pkgPath, "package")
+				diagnostics = append(diagnostics, ts.parseComments(fset, project, groups, source)...)
+			}
+
+		case *ast.GenDecl:
+			kind := declKind(x)
+			groups := cmap[n]
+			if kind == "" || inFunction || len(groups) == 0 {
+				break
+			}
+
+			names := make([]string, 0)
+			for _, spec := range x.Specs {
+				names = append(names, specNames(spec)...)
+			}
+			source := declSource(fset, x.Pos(), pkgPath+"."+strings.Join(names, ","), kind)
+			diagnostics = append(diagnostics, ts.parseComments(fset, project, groups, source)...)
+
+		case *ast.TypeSpec:
+			if groups := cmap[n]; len(groups) > 0 && !inFunction {
+				source := declSource(fset, x.Pos(), pkgPath+"."+TypeSpecName(x), "type")
+				diagnostics = append(diagnostics, ts.parseComments(fset, project, groups, source)...)
+			}
+
+		case *ast.ValueSpec:
+			groups := cmap[n]
+			if len(groups) == 0 || inFunction || len(parents) == 0 {
+				break
+			}
+			if decl, ok := parents[len(parents)-1].(*ast.GenDecl); ok {
+				name := pkgPath + "." + strings.Join(specNames(x), ",")
+				source := declSource(fset, x.Pos(), name, declKind(decl))
+				diagnostics = append(diagnostics, ts.parseComments(fset, project, groups, source)...)
+			}
+
+		case *ast.Field:
+			groups := cmap[n]
+			if len(groups) == 0 || inFunction {
+				break
+			}
+			if name := fieldOwner(x, parents); name != "" {
+				source := declSource(fset, x.Pos(), pkgPath+"."+name, "field")
+				diagnostics = append(diagnostics, ts.parseComments(fset, project, groups, source)...)
+			}
+
 		case *ast.FuncDecl:
 			var fType string
 			// https://www.socketloop.com/references/golang-go-ast-funcdecl-type-example
-			if x.Recv != nil {
-				recvType := x.Recv.List[0].Type
-				if recvStarType, ok := recvType.(*ast.StarExpr); ok {
-					fType = "(*" + recvStarType.X.(*ast.Ident).Name + ")"
-				} else {
-					fType = recvType.(*ast.Ident).Name
-				}
+			if x.Recv != nil && len(x.Recv.List) > 0 {
+				fType = ReceiverName(x.Recv.List[0].Type)
 			} else {
 				fType = ""
 			}
 
-			function := Function{Begin: fset.Position(x.Pos()).Line,
-				Package:  f.Name.String(),
+			function := &Function{Begin: fset.Position(x.Pos()).Line,
+				Package:  pkgPath,
 				Name:     x.Name.String(),
 				Type:     fType,
 				End:      fset.Position(x.End()).Line,
-				Filepath: fset.Position(x.Pos()).Filename,
+				Filepath: displayPath(fset.Position(x.Pos()).Filename),
 				Comments: cmap[n]}
 
-			source := function.ToSource()
-
-			for _, lines := range function.Comments {
-				for _, line := range strings.Split(lines.Text(), "\n") {
-
-					matchType, matches := ts.ParseTrigger(line)
-					if !matches {
-						continue
-					}
-
-					switch matchType {
-					case "mitigates":
-						if id, mitigation := ts.ParseMitigation(line, source); mitigation != nil {
-							ts.AddMitigation(id, mitigation)
-						} else {
-							failedMatches = append(failedMatches, line)
-						}
-					case "exposes":
-						if id, exposure := ts.ParseExposure(line, source); exposure != nil {
-							ts.AddExposure(id, exposure)
-						} else {
-							failedMatches = append(failedMatches, line)
-						}
-					case "transfers":
-						if id, transfer := ts.ParseTransfer(line, source); transfer != nil {
-							ts.AddTransfer(id, transfer)
-						} else {
-							failedMatches = append(failedMatches, line)
-						}
-					case "accepts":
-						if id, acceptance := ts.ParseAcceptance(line, source); acceptance != nil {
-							ts.AddAcceptance(id, acceptance)
-						} else {
-							failedMatches = append(failedMatches, line)
-						}
-					}
+			functions[n] = function
+			if x.Body != nil {
+				bodies[function] = x.Body
+			}
+			diagnostics = append(diagnostics, ts.parseFunctionComments(fset, project, function)...)
+
+		case *ast.FuncLit:
+			// Closures outside of any function run as part of package initialisation
+			parent := initFunction
+			for i := len(parents) - 1; i >= 0; i-- {
+				if enclosing, ok := functions[parents[i]]; ok {
+					parent = enclosing
+					break
+				}
+			}
+			closures[parent]++
+
+			function := &Function{Begin: fset.Position(x.Pos()).Line,
+				Package:  pkgPath,
+				Name:     fmt.Sprintf("func%d", closures[parent]),
+				Parent:   parent,
+				End:      fset.Position(x.End()).Line,
+				Filepath: displayPath(fset.Position(x.Pos()).Filename),
+				Comments: closureComments(fset, f, x, parents)}
+
+			functions[n] = function
+			bodies[function] = x.Body
+			for _, group := range function.Comments {
+				consumed[group] = true
+			}
+			diagnostics = append(diagnostics, ts.parseFunctionComments(fset, project, function)...)
+
+		case *ast.InterfaceType:
+			// Only named interfaces give their methods a usable name
+			if len(parents) == 0 {
+				break
+			}
+			spec, ok := parents[len(parents)-1].(*ast.TypeSpec)
+			if !ok {
+				break
+			}
+
+			for _, field := range x.Methods.List {
+				for _, name := range field.Names {
+					function := &Function{Begin: fset.Position(field.Pos()).Line,
+						Package:  pkgPath,
+						Name:     name.String(),
+						Type:     TypeSpecName(spec),
+						End:      fset.Position(field.End()).Line,
+						Filepath: displayPath(fset.Position(field.Pos()).Filename),
+						Comments: cmap[field]}
+
+					diagnostics = append(diagnostics, ts.parseFunctionComments(fset, project, function)...)
 				}
 			}
 		}
 		return true
 	})
 
-	if len(failedMatches) > 0 {
-		return fmt.Errorf("failed to parse lines:\n - %s\n", strings.Join(failedMatches, "\n - "))
+	// Comments inside a function body belong to the innermost function
+	// containing them, unless they already document a closure
+	for _, group := range f.Comments {
+		if consumed[group] {
+			continue
+		}
+
+		var innermost *Function
+		for function, body := range bodies {
+			if group.Pos() <= body.Lbrace || group.End() >= body.Rbrace {
+				continue
+			}
+			if innermost == nil || body.Lbrace > bodies[innermost].Lbrace {
+				innermost = function
+			}
+		}
+
+		if innermost != nil {
+			diagnostics = append(diagnostics, ts.parseInlineComments(fset, project, innermost, group)...)
+		}
 	}
-	return nil
+
+	return diagnostics
 }
 
-func (ts *ThreatSpec) LoadFile(filename string) error {
+// Load reads a single JSON spec.
+func Load(filename string) (*ThreatSpec, error) {
+	return load(filename, (*ThreatSpec).ValidateJson)
+}
+
+// LoadLibrary reads a JSON spec that may be partial, such as a threat
+// library holding only threats.
+func LoadLibrary(filename string) (*ThreatSpec, error) {
+	return load(filename, (*ThreatSpec).ValidateLibraryJson)
+}
+
+func load(filename string, validate func(*ThreatSpec, string) error) (*ThreatSpec, error) {
 	jsonBlob, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if err := ts.ValidateJson(string(jsonBlob)); err != nil {
-		return err
+	ts := new(ThreatSpec)
+	if err := validate(ts, string(jsonBlob)); err != nil {
+		return nil, err
 	}
 
 	if err := json.Unmarshal(jsonBlob, ts); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// LoadFile merges a JSON spec, which may be a partial library, into ts using
+// Options.MergePolicy. Conflicts are returned as Diagnostics attributed to
+// filename.
+func (ts *ThreatSpec) LoadFile(filename string) error {
+	other, err := LoadLibrary(filename)
+	if err != nil {
 		return err
 	}
+
+	if err := ts.Merge(other, ts.Options.MergePolicy); err != nil {
+		diagnostics := ToDiagnostics(filename, err)
+		for _, diagnostic := range diagnostics {
+			diagnostic.File = displayPath(filename)
+		}
+		return diagnostics
+	}
 	return nil
 }
 
+// LoadFiles merges the given JSON specs, preferring earlier files when they
+// conflict. Conflicts are returned as warning Diagnostics alongside the
+// merged spec; any other error stops loading.
 func LoadFiles(filenames []string) (*ThreatSpec, error) {
 	ts := new(ThreatSpec)
+	diagnostics := make(Diagnostics, 0)
 	for _, filename := range filenames {
-		if err := ts.LoadFile(filename); err != nil {
+		err := ts.LoadFile(filename)
+		if conflicts, ok := err.(Diagnostics); ok {
+			diagnostics = append(diagnostics, conflicts...)
+		} else if err != nil {
 			return nil, err
 		}
 	}
+
+	if len(diagnostics) > 0 {
+		return ts, diagnostics
+	}
 	return ts, nil
 }
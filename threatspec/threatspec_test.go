@@ -0,0 +1,298 @@
+package threatspec
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// parseGo parses src as a Go file outside of any module, so its functions are
+// named after the package.
+func parseGo(t *testing.T, src string) (*ThreatSpec, error) {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"example.go": src})
+	chdir(t, dir)
+
+	ts := New("test")
+	err := ts.ParseSourceFiles([]string{filepath.Join(dir, "example.go")})
+	return ts, err
+}
+
+// sources returns the source of each annotation, keyed by its text.
+func sources(ts *ThreatSpec) map[string]*Source {
+	found := make(map[string]*Source)
+	for _, annotation := range ts.Annotations() {
+		found[annotation.Text] = annotation.Source
+	}
+	return found
+}
+
+func TestReceiverNames(t *testing.T) {
+	ts, err := parseGo(t, `package example
+
+type Page struct{}
+type Set[K comparable, V any] struct{}
+type Pair[T any] struct{}
+
+// @mitigates App:Page against tampering with value receiver
+func (p Page) value() {}
+
+// @mitigates App:Page against tampering with pointer receiver
+func (p *Page) pointer() {}
+
+// @mitigates App:Page against tampering with parenthesised receiver
+func (p (*Page)) parenthesised() {}
+
+// @mitigates App:Page against tampering with parenthesised type
+func ((Page)) bare() {}
+
+// @mitigates App:Set against tampering with generic receiver
+func (s *Set[K, V]) generic() {}
+
+// @mitigates App:Pair against tampering with single type parameter
+func (p Pair[T]) single() {}
+
+// @mitigates App:Pair against tampering with unnamed receiver
+func (Pair[_]) unnamed() {}
+`)
+	if err != nil {
+		t.Fatalf("ParseSourceFiles: %v", err)
+	}
+
+	tests := []struct {
+		mitigation string
+		function   string
+	}{
+		{"value receiver", "example.Page.value"},
+		{"pointer receiver", "example.(*Page).pointer"},
+		{"parenthesised receiver", "example.(*Page).parenthesised"},
+		{"parenthesised type", "example.Page.bare"},
+		{"generic receiver", "example.(*Set[K,V]).generic"},
+		{"single type parameter", "example.Pair[T].single"},
+		{"unnamed receiver", "example.Pair[_].unnamed"},
+	}
+
+	found := sources(ts)
+	for _, test := range tests {
+		source := found[test.mitigation]
+		if source == nil {
+			t.Errorf("%s: annotation not found", test.mitigation)
+		} else if source.Function != test.function {
+			t.Errorf("%s: function = %q, want %q", test.mitigation, source.Function, test.function)
+		}
+	}
+}
+
+func TestClosuresAndInterfaceMethods(t *testing.T) {
+	ts, err := parseGo(t, `package example
+
+import "net/http"
+
+// @exposes App:Store to tampering with unauthenticated writes
+type Store interface {
+	// @mitigates App:Store against tampering with checksums
+	Save(data []byte) error
+}
+
+// @mitigates App:Web against abuse with input validation
+func makeHandler(fn http.HandlerFunc) http.HandlerFunc {
+	first := func() {}
+	_ = first
+	// @mitigates App:Web against abuse with closure validation
+	return func(w http.ResponseWriter, r *http.Request) {
+		fn(w, r)
+	}
+}
+
+func main() {
+	// @exposes App:Web to spoofing with anonymous handler
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+}
+
+// @exposes App:Init to tampering with package level closure
+var handler = func() {}
+`)
+	if err != nil {
+		t.Fatalf("ParseSourceFiles: %v", err)
+	}
+
+	tests := []struct {
+		annotation string
+		function   string
+		kind       string
+	}{
+		{"unauthenticated writes", "example.Store", "type"},
+		{"checksums", "example.Store.Save", "function"},
+		{"input validation", "example.makeHandler", "function"},
+		{"closure validation", "example.makeHandler.func2", "function"},
+		{"anonymous handler", "example.main.func1", "function"},
+		{"package level closure", "example.handler", "var"},
+	}
+
+	found := sources(ts)
+	for _, test := range tests {
+		source := found[test.annotation]
+		if source == nil {
+			t.Errorf("%s: annotation not found", test.annotation)
+			continue
+		}
+		if source.Function != test.function || source.Kind != test.kind {
+			t.Errorf("%s: source = %s %q, want %s %q", test.annotation, source.Kind, source.Function, test.kind, test.function)
+		}
+	}
+}
+
+func TestInlineAnnotations(t *testing.T) {
+	ts, err := parseGo(t, `package example
+
+import "os"
+
+// @accepts tampering to App:FileSystem with documented risk
+func save(name string, data []byte) error {
+	path := name + ".txt"
+	// @exposes App:FileSystem to tampering with unsanitised name
+	err := os.WriteFile(path, data, 0600)
+	write := func() {
+		/* @mitigates App:FileSystem against tampering with fsync */
+	}
+	write()
+	return err
+}
+`)
+	if err != nil {
+		t.Fatalf("ParseSourceFiles: %v", err)
+	}
+
+	tests := []struct {
+		annotation string
+		function   string
+		line       int
+		column     int
+	}{
+		{"documented risk", "example.save", 6, 0},
+		{"unsanitised name", "example.save", 8, 5},
+		{"fsync", "example.save.func1", 11, 6},
+	}
+
+	found := sources(ts)
+	for _, test := range tests {
+		source := found[test.annotation]
+		if source == nil {
+			t.Errorf("%s: annotation not found", test.annotation)
+			continue
+		}
+		if source.Function != test.function || source.Line != test.line || source.Column != test.column {
+			t.Errorf("%s: source = %s:%d:%d, want %s:%d:%d", test.annotation,
+				source.Function, source.Line, source.Column, test.function, test.line, test.column)
+		}
+	}
+}
+
+func TestJoinContinuations(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string
+	}{
+		{
+			"backslash",
+			[]string{" @mitigates App:Web against abuse \\", " with validation"},
+			[]string{" @mitigates App:Web against abuse with validation"},
+		},
+		{
+			"indented",
+			[]string{"@alias threat @xss to Cross", "    site scripting", "  in templates", "not continued"},
+			[]string{"@alias threat @xss to Cross site scripting in templates", "not continued"},
+		},
+		{
+			"blank line ends annotation",
+			[]string{"@exposes App:Web to abuse \\", "", "    with nothing"},
+			[]string{"@exposes App:Web to abuse", "", "    with nothing"},
+		},
+		{
+			"trigger starts a new annotation",
+			[]string{"@exposes App:Web to abuse with a", "  @mitigates App:Web against abuse with b"},
+			[]string{"@exposes App:Web to abuse with a", "  @mitigates App:Web against abuse with b"},
+		},
+		{
+			"plain comments are not joined",
+			[]string{"Save writes a page", "    to disk"},
+			[]string{"Save writes a page", "    to disk"},
+		},
+	}
+
+	for _, test := range tests {
+		lines := make([]commentLine, 0, len(test.lines))
+		for i, text := range test.lines {
+			lines = append(lines, commentLine{text, i + 1, 1})
+		}
+
+		joined := joinContinuations(lines)
+		got := make([]string, 0, len(joined))
+		for _, line := range joined {
+			got = append(got, line.text)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: joinContinuations = %q, want %q", test.name, got, test.want)
+		}
+		if len(joined) > 0 && joined[0].line != 1 {
+			t.Errorf("%s: joined annotation starts on line %d, want 1", test.name, joined[0].line)
+		}
+	}
+}
+
+func TestContinuedAnnotations(t *testing.T) {
+	ts, err := parseGo(t, `package example
+
+// @mitigates App:FileSystem against unauthorised access \
+// with strict file permissions
+func save() {}
+`)
+	if err != nil {
+		t.Fatalf("ParseSourceFiles: %v", err)
+	}
+	if source := sources(ts)["strict file permissions"]; source == nil || source.Function != "example.save" {
+		t.Errorf("continued mitigation not attributed to example.save: %+v", source)
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"cwe.threatspec": `@alias threat @cleartext to Cleartext
+    transmission of sensitive data [stride: information disclosure;
+    cwe: 319; severity: high]
+`})
+	ts = New("test")
+	if err := ts.ParseSpecFile(filepath.Join(dir, "cwe.threatspec")); err != nil {
+		t.Fatalf("ParseSpecFile: %v", err)
+	}
+	threat := ts.Threats["@cleartext"]
+	if threat == nil || threat.Name != "Cleartext transmission of sensitive data" || threat.Severity != "high" {
+		t.Errorf("continued alias = %+v", threat)
+	}
+}
+
+func TestDefinitionNames(t *testing.T) {
+	ts := New("wiki")
+	ts.AddBoundary("@webapp", "WebApp")
+	ts.AddComponent("@app", "App")
+	ts.AddThreat("@xss", "XSS")
+
+	tests := []struct {
+		name func(Id) string
+		id   Id
+		want string
+	}{
+		{ts.BoundaryName, "@webapp", "WebApp"},
+		{ts.ComponentName, "@app", "App"},
+		{ts.ThreatName, "@xss", "XSS"},
+		{ts.BoundaryName, "@app", "@app"},
+		{ts.ComponentName, "@undefined", "@undefined"},
+		{ts.ThreatName, "@undefined", "@undefined"},
+	}
+
+	for _, test := range tests {
+		if got := test.name(test.id); got != test.want {
+			t.Errorf("name(%s) = %q, want %q", test.id, got, test.want)
+		}
+	}
+}
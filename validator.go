@@ -9,9 +9,17 @@ import (
 
 func main() {
 	flag.Parse()
-	_, err := threatspec.LoadFiles(flag.Args())
-	if err != nil {
-		fmt.Println(err)
+
+	// Each file must be a complete spec, unlike the libraries the report
+	// tools merge
+	failed := false
+	for _, filename := range flag.Args() {
+		if _, err := threatspec.Load(filename); err != nil {
+			fmt.Printf("%s: %s\n", filename, err)
+			failed = true
+		}
+	}
+	if failed {
 		os.Exit(1)
 	}
 